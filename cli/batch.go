@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+// batchEntry is one line of the --ip-file, and the result of its login/out
+type batchEntry struct {
+	ip       string
+	username string
+	result   string
+	err      error
+}
+
+// parseIPFile reads lines of "IP [username]", skipping blank lines and
+// comments starting with #
+func parseIPFile(name string) ([]*batchEntry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []*batchEntry{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 || net.ParseIP(fields[0]) == nil {
+			return nil, fmt.Errorf("%s:%d: expect \"IP [username]\", got \"%s\"", name, line, strings.TrimSpace(text))
+		}
+		e := &batchEntry{ip: fields[0]}
+		if len(fields) == 2 {
+			e.username = fields[1]
+		}
+		entries = append(entries, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no IP address in %s", name)
+	}
+	return entries, nil
+}
+
+// run logs in/out one IP address, skipping it if the session is already
+// in the desired state. The hooks of the workers are serialized by
+// emitEvent.
+func (e *batchEntry) run(ctx context.Context, client *libauth.Client, logout bool) {
+	if !settings.NoCheck {
+		info, err := client.UserInfo(ctx, e.ip)
+		if err != nil {
+			logger.Debugf("%s: Online check failed: %s\n", e.ip, err)
+		} else if info.Online && !logout {
+			e.result = "already online"
+			return
+		} else if !info.Online && logout {
+			e.result = "already offline"
+			return
+		} else if logout && len(info.Username) != 0 {
+			// Log out whoever is online, as authUtil does
+			e.username = info.Username
+		}
+	}
+	username := e.username
+	if settings.Campus && !strings.HasSuffix(username, "@tsinghua") {
+		username += "@tsinghua"
+	}
+	action := "Login"
+	if logout {
+		action = "Logout"
+	}
+	e.err = withRetry(ctx, e.ip+" "+action, func() (err error) {
+		if logout {
+			_, err = client.Logout(ctx, username, e.ip)
+		} else {
+			_, err = client.Login(ctx, username, settings.Password, e.ip)
+		}
+		return
+	})
+	if e.err != nil {
+		emitEvent(newHookEvent(actionFailure, username, e.ip, e.err))
+		e.err = localize(e.err)
+		e.result = "failed: " + e.err.Error()
+		return
+	}
+	emitEvent(newHookEvent(authAction(logout), username, e.ip, nil))
+	if logout {
+		e.result = "logged out"
+	} else {
+		e.result = "logged in"
+	}
+}
+
+// batchAuth logs in/out every IP address of the --ip-file, running at
+// most --jobs requests at a time
+func batchAuth(ctx context.Context, c *cli.Command, logout bool) error {
+	if len(settings.Ip) != 0 || settings.DualStack || settings.KeepOn {
+		return fmt.Errorf("--ip-file cannot be used with ip, dual-stack or keep-online")
+	}
+	entries, err := parseIPFile(c.String("ip-file"))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if len(e.username) == 0 {
+			if err = requestUser(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if !logout {
+		if err = requestPasswd(); err != nil {
+			return err
+		}
+	}
+
+	acID := "1"
+	if len(settings.AcID) != 0 {
+		acID = settings.AcID
+	}
+	client := libauth.NewClient(libauth.NewUrlProvider(portalDomain(), settings.Insecure), acID)
+	jobs := c.Int("jobs")
+	if jobs < 1 {
+		jobs = 1
+	}
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for _, e := range entries {
+		if len(e.username) == 0 {
+			e.username = settings.Username
+		}
+		wg.Add(1)
+		go func(e *batchEntry) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			e.run(ctx, client, logout)
+		}(e)
+	}
+	wg.Wait()
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IP\tUSERNAME\tRESULT")
+	for _, e := range entries {
+		if e.err != nil {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.ip, e.username, e.result)
+	}
+	w.Flush()
+
+	if failed != 0 {
+		return fmt.Errorf("%d of %d addresses failed", failed, len(entries))
+	}
+	return nil
+}
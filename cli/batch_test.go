@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeIPFile writes content to a file in dir and returns its path
+func writeIPFile(dir, content string) string {
+	name := filepath.Join(dir, "ips.txt")
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		panic(err)
+	}
+	return name
+}
+
+func TestParseIPFile(t *testing.T) {
+	dir := t.TempDir()
+
+	Convey("parseIPFile should skip comments and blank lines", t, func() {
+		entries, err := parseIPFile(writeIPFile(dir, "# lab machines\n\n166.111.0.1\n  166.111.0.2   bob  # printer\n\n2402:f000::1\n"))
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 3)
+		So(*entries[0], ShouldResemble, batchEntry{ip: "166.111.0.1"})
+		So(*entries[1], ShouldResemble, batchEntry{ip: "166.111.0.2", username: "bob"})
+		So(*entries[2], ShouldResemble, batchEntry{ip: "2402:f000::1"})
+	})
+
+	Convey("parseIPFile should report bad lines with the line number", t, func() {
+		_, err := parseIPFile(writeIPFile(dir, "166.111.0.1\n166.111.0.256 bob\n"))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "ips.txt:2:")
+
+		_, err = parseIPFile(writeIPFile(dir, "166.111.0.1 bob alice\n"))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "ips.txt:1:")
+	})
+
+	Convey("parseIPFile should fail without addresses", t, func() {
+		_, err := parseIPFile(writeIPFile(dir, "# nothing\n\n"))
+		So(err, ShouldNotBeNil)
+
+		_, err = parseIPFile(filepath.Join(dir, "missing.txt"))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/z4yx/GoAuthing/notify"
+)
+
+// validHost checks a host name or IP address, with an optional port
+func validHost(host string) bool {
+	u, err := url.Parse("//" + host)
+	return err == nil && u.Host == host && len(u.Hostname()) != 0 && u.User == nil
+}
+
+// validateSettings checks the merged settings for invalid values and
+// combinations, which would otherwise only fail at runtime
+func validateSettings(s *Settings) error {
+	errs := []error{}
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(len(s.Ip) == 0 || net.ParseIP(s.Ip) != nil, "ip \"%s\" is not an IP address", s.Ip)
+	check(len(s.Host) == 0 || validHost(s.Host), "host \"%s\" is not a host name with an optional port", s.Host)
+	if len(s.AcID) != 0 {
+		n, err := strconv.Atoi(s.AcID)
+		check(err == nil && n >= 0, "acId \"%s\" is not a number", s.AcID)
+	}
+	check(s.OnIntrvl >= 1 && s.OnIntrvl <= 3600, "onlineInterval %d is out of range 1-3600", s.OnIntrvl)
+	check(s.OnRetry >= 0, "onlineRetry %d is negative", s.OnRetry)
+	check(s.CheckIntrvl >= 0 && s.CheckIntrvl <= 86400, "checkInterval %d is out of range 0-86400", s.CheckIntrvl)
+	check(s.V6Intrvl <= 86400, "keepAliveV6Interval %d is over 86400", s.V6Intrvl)
+	check(s.Timeout >= 0 && s.Timeout <= 600, "timeout %d is out of range 0-600", s.Timeout)
+	check(s.LoginRetries >= 0, "loginRetries %d is negative", s.LoginRetries)
+	check(s.RetryBackoff >= 0, "retryBackoff %d is negative", s.RetryBackoff)
+	check(s.RetryMaxBackoff >= 0, "retryMaxBackoff %d is negative", s.RetryMaxBackoff)
+	check(s.HookTimeout >= 0, "hookTimeout %d is negative", s.HookTimeout)
+	check(s.RetryJitter >= 0 && s.RetryJitter <= 1, "retryJitter %v is out of range 0-1", s.RetryJitter)
+	for _, t := range s.KeepAliveTargets {
+		_, err := newProbe(t, s.V6)
+		check(err == nil, "%v", err)
+		check(t.Interval >= 0, "interval %d of keepalive target %s is negative", t.Interval, t.Address)
+	}
+	for _, w := range s.Webhooks {
+		check(w.Validate() == nil, "%v", w.Validate())
+	}
+	if len(s.MetricsListen) != 0 {
+		_, port, err := net.SplitHostPort(s.MetricsListen)
+		check(err == nil && len(port) != 0, "metricsListen \"%s\" is not an address like :9417", s.MetricsListen)
+	}
+	if len(s.ControlListen) != 0 {
+		host, _, err := net.SplitHostPort(s.ControlListen)
+		ip := net.ParseIP(host)
+		check(err == nil && (host == "localhost" || ip != nil && ip.IsLoopback()), "controlListen \"%s\" is not a loopback address like 127.0.0.1:9418", s.ControlListen)
+	}
+	switch s.Keyring {
+	case "", "auto", "secret-service", "file", "none":
+	default:
+		check(false, "keyring \"%s\" is not one of auto, secret-service, file and none", s.Keyring)
+	}
+
+	check(!s.DualStack || (len(s.Ip) == 0 && len(s.Host) == 0), "dualStack cannot be used with ip or host")
+	check(!s.PasswordStdin || len(s.PasswordFile) == 0, "passwordStdin cannot be used with passwordFile")
+	return errors.Join(errs...)
+}
+
+// secretMask replaces the secrets in the output of config check
+const secretMask = "********"
+
+// maskedSettings returns the settings to print, without secrets. The
+// password command is masked too, as it may include the password, and so
+// are the header values (e.g. Authorization) and the user info in the
+// URLs of the webhooks.
+func maskedSettings(s Settings) Settings {
+	if len(s.Password) != 0 {
+		s.Password = secretMask
+	}
+	if len(s.PasswordCmd) != 0 {
+		s.PasswordCmd = secretMask
+	}
+	webhooks := make([]notify.Webhook, len(s.Webhooks))
+	for i, w := range s.Webhooks {
+		if u, err := url.Parse(w.URL); err != nil {
+			w.URL = secretMask
+		} else if u.User != nil {
+			// url.User would escape the mask
+			u.User = nil
+			w.URL = strings.Replace(u.String(), "//", "//"+secretMask+"@", 1)
+		}
+		if len(w.Headers) != 0 {
+			headers := make(map[string]string, len(w.Headers))
+			for name := range w.Headers {
+				headers[name] = secretMask
+			}
+			w.Headers = headers
+		}
+		webhooks[i] = w
+	}
+	if len(s.Webhooks) != 0 {
+		s.Webhooks = webhooks
+	}
+	s.Profiles = nil
+	return s
+}
+
+// checkProfiles decodes every profile of the config file, so that errors
+// of the profiles not in use are found too
+func checkProfiles(top Settings) error {
+	names := make([]string, 0, len(top.Profiles))
+	for name := range top.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := []error{}
+	for _, name := range names {
+		if _, err := profileSettings(&top, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func cmdConfigCheck(ctx context.Context, c *cli.Command) error {
+	cf := locateConfigFile(c)
+	err := parseSettings(c)
+	if err == nil && len(cf) != 0 {
+		var top Settings
+		bv, _ := os.ReadFile(cf)
+		if err = decodeConfig(cf, bv, &top); err == nil {
+			err = checkProfiles(top)
+		}
+	}
+	if err != nil {
+		logger.Errorf("Config error: %s\n", err)
+		exit(1)
+	}
+
+	if len(cf) != 0 {
+		logger.Infof("Config file \"%s\" is valid\n", cf)
+	}
+	b, _ := json.MarshalIndent(maskedSettings(settings), "", "  ")
+	fmt.Println(string(b))
+	return nil
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// tomlLine matches "key = value" and "[table]" lines, which are not valid
+// in a YAML mapping
+var tomlLine = regexp.MustCompile(`^\s*(\[|[A-Za-z0-9_."-]+\s*=)`)
+
+// configFormat chooses the format of a config file by its extension, or
+// by its content if the extension is unknown (e.g. ~/.auth-thu)
+func configFormat(path string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return formatJSON
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "{") {
+			return formatJSON
+		}
+		if tomlLine.MatchString(line) {
+			return formatTOML
+		}
+		return formatYAML
+	}
+	return formatJSON
+}
+
+// decodeConfig decodes a JSON, YAML or TOML config file into v, which is
+// decoded with its json tags in all formats. Unknown keys are errors.
+// Errors tell the line and column where possible.
+func decodeConfig(path string, data []byte, v any) error {
+	var generic any
+	switch configFormat(path, data) {
+	case formatYAML:
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("%s: %s", path, strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+		if len(doc.Content) == 0 {
+			// Empty document
+			return nil
+		}
+		if err := doc.Decode(&generic); err != nil {
+			return fmt.Errorf("%s: %s", path, strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+		return decodeGeneric(path, generic, v, locator{
+			field: func(field string) (int, int) {
+				return yamlPosition(doc.Content[0], strings.Split(field, "."))
+			},
+			key: func(name string) (int, int) {
+				return yamlKeyPosition(doc.Content[0], name)
+			},
+		})
+	case formatTOML:
+		if _, err := toml.Decode(string(data), &generic); err != nil {
+			var pe toml.ParseError
+			if errors.As(err, &pe) {
+				return fmt.Errorf("%s: line %d, column %d: %s", path, pe.Position.Line, pe.Position.Col, pe.Message)
+			}
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		return decodeGeneric(path, generic, v, locator{
+			field: func(field string) (int, int) {
+				return tomlPosition(data, field, false)
+			},
+			key: func(name string) (int, int) {
+				return tomlPosition(data, name, true)
+			},
+		})
+	}
+
+	err := decodeStrict(data, v)
+	var se *json.SyntaxError
+	var te *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &se):
+		// Offset is after the invalid character
+		line, col := offsetPosition(data, se.Offset-1)
+		return fmt.Errorf("%s: line %d, column %d: %s", path, line, col, strings.TrimPrefix(se.Error(), "json: "))
+	case errors.As(err, &te):
+		line, col := offsetPosition(data, jsonValueStart(data, te.Offset))
+		return fmt.Errorf("%s: line %d, column %d: %s", path, line, col, typeErrorMessage(te))
+	}
+	return configError(path, err, locator{key: func(name string) (int, int) {
+		return jsonKeyPosition(data, name)
+	}})
+}
+
+// locator finds the positions in a config file, or returns 0 if not found
+type locator struct {
+	// field finds the value of a dotted field path
+	field func(field string) (int, int)
+	// key finds the first key with the name
+	key func(name string) (int, int)
+}
+
+func noPosition(string) (int, int) {
+	return 0, 0
+}
+
+// unknownKeyError is an unknown key of the config, including keys
+// differing only in case, which encoding/json would accept
+type unknownKeyError struct {
+	Key string
+	// Guess is the known key the user may have meant
+	Guess string
+}
+
+func (e *unknownKeyError) Error() string {
+	if len(e.Guess) != 0 {
+		return fmt.Sprintf("unknown key \"%s\" (did you mean \"%s\"?)", e.Key, e.Guess)
+	}
+	return fmt.Sprintf("unknown key \"%s\"", e.Key)
+}
+
+// checkKeys checks that every key of a decoded JSON value matches a json
+// tag of t exactly. Maps (e.g. profiles) are not checked.
+func checkKeys(value any, t reflect.Type) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch v := value.(type) {
+	case []any:
+		if t.Kind() == reflect.Slice {
+			for _, elem := range v {
+				if err := checkKeys(elem, t.Elem()); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			fields[key] = t.Field(i).Type
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			ft, exist := fields[key]
+			if !exist {
+				e := &unknownKeyError{Key: key}
+				for known := range fields {
+					if strings.EqualFold(known, key) {
+						e.Guess = known
+					}
+				}
+				return e
+			}
+			if err := checkKeys(v[key], ft); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeStrict decodes JSON into v, failing on unknown keys
+func decodeStrict(data []byte, v any) error {
+	var generic any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	if err := checkKeys(generic, reflect.TypeOf(v)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeGeneric decodes a YAML/TOML document into v via JSON, so that the
+// same keys are used in all formats
+func decodeGeneric(path string, generic any, v any, loc locator) error {
+	data, err := json.Marshal(generic)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return configError(path, decodeStrict(data, v), loc)
+}
+
+// configError adds the position of the type error or unknown key to err
+func configError(path string, err error, loc locator) error {
+	if err == nil {
+		return nil
+	}
+	var te *json.UnmarshalTypeError
+	if errors.As(err, &te) {
+		if line, col := loc.field(te.Field); line != 0 {
+			return fmt.Errorf("%s: line %d, column %d: %s", path, line, col, typeErrorMessage(te))
+		}
+		return fmt.Errorf("%s: %s", path, typeErrorMessage(te))
+	}
+	var ke *unknownKeyError
+	if errors.As(err, &ke) {
+		if line, col := loc.key(ke.Key); line != 0 {
+			return fmt.Errorf("%s: line %d, column %d: %s", path, line, col, ke)
+		}
+		return fmt.Errorf("%s: %s", path, ke)
+	}
+	return fmt.Errorf("%s: %s", path, strings.TrimPrefix(err.Error(), "json: "))
+}
+
+func typeErrorMessage(te *json.UnmarshalTypeError) string {
+	if len(te.Field) == 0 {
+		return fmt.Sprintf("cannot use %s as %s", te.Value, te.Type)
+	}
+	return fmt.Sprintf("cannot use %s as %s for \"%s\"", te.Value, te.Type, te.Field)
+}
+
+// offsetPosition converts a byte offset to 1-based line and column
+func offsetPosition(data []byte, offset int64) (line, col int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = int(offset) - bytes.LastIndexByte(before, '\n')
+	return
+}
+
+// jsonValueStart returns the offset of the value of a type error, whose
+// Offset is after the value for literals and after [ or { otherwise
+func jsonValueStart(data []byte, offset int64) int64 {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset <= 0 {
+		return 0
+	}
+	if data[offset-1] == '[' || data[offset-1] == '{' {
+		return offset - 1
+	}
+	i := offset
+	if data[i-1] == '"' {
+		// Back to the opening quote
+		for i--; i > 0; i-- {
+			if data[i-1] == '"' && (i < 2 || data[i-2] != '\\') {
+				return i - 1
+			}
+		}
+		return 0
+	}
+	for i > 0 && !strings.ContainsRune(" \t\r\n:,[", rune(data[i-1])) {
+		i--
+	}
+	return i
+}
+
+// jsonKeyPosition finds the first "name": in a JSON document
+func jsonKeyPosition(data []byte, name string) (int, int) {
+	re := regexp.MustCompile(regexp.QuoteMeta(strconv.Quote(name)) + `\s*:`)
+	loc := re.FindIndex(data)
+	if loc == nil {
+		return 0, 0
+	}
+	return offsetPosition(data, int64(loc[0]))
+}
+
+// yamlKeyPosition finds the first key with the name in a YAML node
+func yamlKeyPosition(node *yaml.Node, name string) (int, int) {
+	for i, child := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 && child.Value == name {
+			return child.Line, child.Column
+		}
+		if line, col := yamlKeyPosition(child, name); line != 0 {
+			return line, col
+		}
+	}
+	return 0, 0
+}
+
+// yamlPosition finds the value of a field path (e.g. "keepAliveTargets.0.
+// interval") in a YAML node
+func yamlPosition(node *yaml.Node, field []string) (int, int) {
+	if len(field) == 0 {
+		return node.Line, node.Column
+	}
+	switch node.Kind {
+	case yaml.SequenceNode:
+		if i, err := strconv.Atoi(field[0]); err == nil && i >= 0 && i < len(node.Content) {
+			return yamlPosition(node.Content[i], field[1:])
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == field[0] {
+				return yamlPosition(node.Content[i+1], field[1:])
+			}
+		}
+	}
+	return 0, 0
+}
+
+// tomlPosition finds the "key = value" of a field path in a TOML document,
+// by tracking the [table] and [[array]] headers. If byName is set, field
+// is the name of the key in any table instead.
+func tomlPosition(data []byte, field string, byName bool) (int, int) {
+	table := ""
+	arrays := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if strings.HasPrefix(trimmed, "[[") {
+			name := strings.Trim(trimmed, "[] ")
+			table = name + "." + strconv.Itoa(arrays[name])
+			arrays[name]++
+			continue
+		} else if strings.HasPrefix(trimmed, "[") {
+			table = strings.Trim(trimmed, "[] ")
+			continue
+		}
+		key, _, found := strings.Cut(trimmed, "=")
+		if !found {
+			continue
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		if byName {
+			if key == field {
+				return line, strings.Index(text, key) + 1
+			}
+			continue
+		}
+		if len(table) != 0 {
+			key = table + "." + key
+		}
+		if key == field {
+			// The column of the value after "= "
+			eq := strings.Index(text, "=")
+			value := strings.TrimLeft(text[eq+1:], " \t")
+			return line, len(text) - len(value) + 1
+		}
+	}
+	return 0, 0
+}
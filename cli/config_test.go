@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfigFormat(t *testing.T) {
+	Convey("configFormat should choose the format by the extension", t, func() {
+		cases := map[string]string{
+			"/etc/goauthing.json": formatJSON,
+			"config.YAML":         formatYAML,
+			"config.yml":          formatYAML,
+			"config.toml":         formatTOML,
+		}
+		for path, format := range cases {
+			So(configFormat(path, []byte("username: alice")), ShouldEqual, format)
+		}
+	})
+
+	Convey("configFormat should sniff the content without an extension", t, func() {
+		cases := map[string]string{
+			"{\"username\": \"alice\"}":           formatJSON,
+			"# comment\n\n  {\n}":                 formatJSON,
+			"username: alice\n":                   formatYAML,
+			"# username = alice\nusername: alice": formatYAML,
+			"username = \"alice\"\n":              formatTOML,
+			"\n[profiles.lab]\nip = \"1.2.3.4\"":  formatTOML,
+			"":                                    formatJSON,
+		}
+		for content, format := range cases {
+			So(configFormat("/root/.auth-thu", []byte(content)), ShouldEqual, format)
+		}
+	})
+}
+
+func TestDecodeConfig(t *testing.T) {
+	Convey("decodeConfig should decode every format with the json tags", t, func() {
+		cases := map[string]string{
+			"a.json": `{"username": "alice", "onlineInterval": 5, "keepAliveTargets": [{"type": "tcp", "address": "1.2.3.4:443"}]}`,
+			"a.yaml": "username: alice\nonlineInterval: 5\nkeepAliveTargets:\n  - type: tcp\n    address: 1.2.3.4:443\n",
+			"a.toml": "username = \"alice\"\nonlineInterval = 5\n[[keepAliveTargets]]\ntype = \"tcp\"\naddress = \"1.2.3.4:443\"\n",
+		}
+		for path, content := range cases {
+			var s Settings
+			So(decodeConfig(path, []byte(content), &s), ShouldBeNil)
+			So(s.Username, ShouldEqual, "alice")
+			So(s.OnIntrvl, ShouldEqual, 5)
+			So(s.KeepAliveTargets, ShouldResemble, []ProbeTarget{{Type: "tcp", Address: "1.2.3.4:443"}})
+		}
+	})
+
+	Convey("decodeConfig should reject unknown keys with their position", t, func() {
+		cases := []struct{ path, content, message string }{
+			{"a.json", "{\n  \"username\": \"alice\",\n  \"onlineinterval\": 5\n}", `a.json: line 3, column 3: unknown key "onlineinterval" (did you mean "onlineInterval"?)`},
+			{"a.yaml", "username: alice\nkeepAliveTargets:\n  - type: tcp\n    adress: 1.2.3.4:443\n", `a.yaml: line 4, column 5: unknown key "adress"`},
+			{"a.toml", "username = \"alice\"\n\n[[keepAliveTargets]]\ntype = \"tcp\"\n  adress = \"x\"\n", `a.toml: line 5, column 3: unknown key "adress"`},
+		}
+		for _, c := range cases {
+			var s Settings
+			err := decodeConfig(c.path, []byte(c.content), &s)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, c.message)
+		}
+	})
+
+	Convey("decodeConfig should report type and syntax errors with their position", t, func() {
+		cases := []struct{ path, content, message string }{
+			{"a.json", "{\n  \"onlineInterval\": \"5\"\n}", `a.json: line 2, column 21: cannot use string as int for "onlineInterval"`},
+			{"a.json", "{\n  \"username\": \"alice\"\n  \"ip\": \"1.2.3.4\"\n}", `a.json: line 3, column 3: invalid character '"' after object key:value pair`},
+			{"a.yaml", "username: alice\nkeepAliveTargets:\n  - type: tcp\n    interval: soon\n", `a.yaml: line 4, column 15: cannot use string as int for "keepAliveTargets.0.interval"`},
+			{"a.toml", "username = \"alice\"\n[[keepAliveTargets]]\ninterval = \"soon\"\n", `a.toml: line 3, column 12: cannot use string as int for "keepAliveTargets.0.interval"`},
+		}
+		for _, c := range cases {
+			var s Settings
+			err := decodeConfig(c.path, []byte(c.content), &s)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, c.message)
+		}
+	})
+}
+
+func TestTOMLPosition(t *testing.T) {
+	data := []byte("username = \"alice\"\n\n[profiles.lab]\nip = \"1.2.3.4\"\n\n[[keepAliveTargets]]\ntype = \"tcp\"\n\n[[keepAliveTargets]]\n  \"interval\" = 30\n")
+
+	Convey("tomlPosition should find fields by path", t, func() {
+		line, col := tomlPosition(data, "username", false)
+		So([]int{line, col}, ShouldResemble, []int{1, 12})
+		line, col = tomlPosition(data, "profiles.lab.ip", false)
+		So([]int{line, col}, ShouldResemble, []int{4, 6})
+		line, col = tomlPosition(data, "keepAliveTargets.1.interval", false)
+		So([]int{line, col}, ShouldResemble, []int{10, 16})
+		line, col = tomlPosition(data, "keepAliveTargets.0.interval", false)
+		So([]int{line, col}, ShouldResemble, []int{0, 0})
+	})
+
+	Convey("tomlPosition should find keys by name", t, func() {
+		line, col := tomlPosition(data, "type", true)
+		So([]int{line, col}, ShouldResemble, []int{7, 1})
+		line, col = tomlPosition(data, "interval", true)
+		So([]int{line, col}, ShouldResemble, []int{10, 4})
+		line, col = tomlPosition(data, "missing", true)
+		So([]int{line, col}, ShouldResemble, []int{0, 0})
+	})
+
+	Convey("jsonValueStart should find the value of a type error", t, func() {
+		cases := map[string]int64{
+			`{"n": "x\"y"}`:  6,
+			`{"n": true}`:    6,
+			`{"n": 1.5}`:     6,
+			`{"n": [1, 2]}`:  6,
+			`{"n": {"a":1}}`: 6,
+		}
+		for data, start := range cases {
+			var s struct {
+				N int `json:"n"`
+			}
+			err := decodeStrict([]byte(data), &s)
+			var te *json.UnmarshalTypeError
+			So(errors.As(err, &te), ShouldBeTrue)
+			So(jsonValueStart([]byte(data), te.Offset), ShouldEqual, start)
+		}
+	})
+
+	Convey("offsetPosition should count lines and columns from 1", t, func() {
+		line, col := offsetPosition([]byte("ab\ncd"), 4)
+		So([]int{line, col}, ShouldResemble, []int{2, 2})
+		line, col = offsetPosition([]byte("ab"), 10)
+		So([]int{line, col}, ShouldResemble, []int{1, 3})
+	})
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+// controller is what the control API acts on, shared by the daemon and
+// keepAliveLoop
+type controller struct {
+	// mu serializes the actions
+	mu   sync.Mutex
+	mode string
+	// state is the daemon state, or "online" for keepAliveLoop
+	state atomic.Value
+	// paused stops the keepAlive probes and the re-login of the daemon
+	paused atomic.Bool
+	// wake makes the daemon check the session now
+	wake chan struct{}
+	// restart makes keepAliveLoop restart with the current settings
+	restart chan struct{}
+}
+
+var control = &controller{
+	wake:    make(chan struct{}, 1),
+	restart: make(chan struct{}, 1),
+}
+
+// poke sends to a buffered channel without blocking
+func poke(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (ctl *controller) setState(s string) {
+	ctl.state.Store(s)
+}
+
+// controlStatus is the reply of GET /status
+type controlStatus struct {
+	Mode          string        `json:"mode"`
+	State         string        `json:"state"`
+	Paused        bool          `json:"paused"`
+	Username      string        `json:"username,omitempty"`
+	IP            string        `json:"ip,omitempty"`
+	UptimeSeconds int64         `json:"uptimeSeconds"`
+	Session       *statusOutput `json:"session,omitempty"`
+	// Error tells why Session is missing
+	Error string `json:"error,omitempty"`
+}
+
+// controlReply is the reply of the actions, and of failed requests
+type controlReply struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+	Ecode   string `json:"ecode,omitempty"`
+}
+
+func (ctl *controller) status(ctx context.Context) controlStatus {
+	s := snapshot()
+	st := controlStatus{
+		Mode:          ctl.mode,
+		Paused:        ctl.paused.Load(),
+		Username:      s.Username,
+		IP:            s.Ip,
+		UptimeSeconds: int64(time.Since(startTime) / time.Second),
+	}
+	st.State, _ = ctl.state.Load().(string)
+	info, err := sessionInfo(ctx, s)
+	if err != nil {
+		st.Error = err.Error()
+	} else {
+		out := newStatusOutput(info)
+		st.Session = &out
+	}
+	return st
+}
+
+// sessionInfo queries the session of this machine, or of s.Ip
+func sessionInfo(ctx context.Context, s *Settings) (*libauth.UserInfo, error) {
+	acID := "1"
+	if len(s.AcID) != 0 {
+		acID = s.AcID
+	}
+	client := libauth.NewClient(libauth.NewUrlProvider(domainOf(s), s.Insecure), acID)
+	if len(s.Ip) != 0 {
+		return client.UserInfo(ctx, s.Ip)
+	}
+	return client.Status(ctx)
+}
+
+// credentials returns the user name and password of s to log in with,
+// without prompting, as the daemon may have been started with them given
+// on the command line only. The password read from its sources is not
+// kept.
+func credentials(s *Settings) (username, password string, err error) {
+	username = s.Username
+	if len(username) == 0 {
+		return "", "", fmt.Errorf("username is not set")
+	}
+	password = s.Password
+	if len(password) == 0 && !s.PasswordStdin {
+		if password, err = passwordFromSources(s); err != nil {
+			return "", "", err
+		}
+	}
+	if len(password) == 0 {
+		password = keyringPassword(s)
+	}
+	if len(password) == 0 {
+		return "", "", fmt.Errorf("password is not set")
+	}
+	if s.Campus && !strings.HasSuffix(username, "@tsinghua") {
+		username += "@tsinghua"
+	}
+	return username, password, nil
+}
+
+// loginNow logs in with the current settings, reporting the result to
+// the hooks and webhooks
+func loginNow(ctx context.Context) (string, error) {
+	s := snapshot()
+	username, password, err := credentials(s)
+	if err != nil {
+		return "", err
+	}
+	host, acID := portalParamsOf(ctx, s)
+	_, err = libauth.NewClient(host, acID).Login(ctx, username, password, s.Ip)
+	observeAuth("Login", err)
+	switch {
+	case errors.Is(err, libauth.ErrAlreadyOnline):
+		return "Currently online!", nil
+	case err != nil:
+		emitEvent(newHookEvent(actionFailure, username, s.Ip, err))
+		return "", localize(err)
+	}
+	logger.Infof("Login Successfully!\n")
+	emitEvent(newHookEvent(actionLogin, username, s.Ip, nil))
+	return "Login Successfully!", nil
+}
+
+// logoutSession logs out of the session of s, if online, reporting the
+// result to the hooks and webhooks
+func logoutSession(ctx context.Context, s *Settings) (online bool, err error) {
+	host, acID := portalParamsOf(ctx, s)
+	client := libauth.NewClient(host, acID)
+	var info *libauth.UserInfo
+	if len(s.Ip) != 0 {
+		info, err = client.UserInfo(ctx, s.Ip)
+	} else {
+		info, err = client.Status(ctx)
+	}
+	if err != nil || !info.Online {
+		return false, err
+	}
+	username := info.Username
+	if len(username) == 0 {
+		username = s.Username
+	}
+	_, err = client.Logout(ctx, username, s.Ip)
+	observeAuth("Logout", err)
+	if err != nil {
+		emitEvent(newHookEvent(actionFailure, username, s.Ip, err))
+		return true, localize(err)
+	}
+	logger.Infof("Logout Successfully!\n")
+	emitEvent(newHookEvent(actionLogout, username, s.Ip, nil))
+	return true, nil
+}
+
+// login logs in now, even if the daemon is backing off, and resumes
+func (ctl *controller) login(ctx context.Context) (string, error) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	message, err := loginNow(ctx)
+	if err != nil {
+		return "", err
+	}
+	ctl.paused.Store(false)
+	poke(ctl.wake)
+	return message, nil
+}
+
+// logout logs out and pauses, so that the session is not brought back
+// until login or resume
+func (ctl *controller) logout(ctx context.Context) (string, error) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	ctl.paused.Store(true)
+	online, err := logoutSession(ctx, snapshot())
+	if err != nil {
+		return "", err
+	}
+	if !online {
+		return "Currently offline!", nil
+	}
+	poke(ctl.wake)
+	return "Logout Successfully! Paused until login or resume.", nil
+}
+
+func (ctl *controller) pause() string {
+	ctl.paused.Store(true)
+	logger.Infof("Paused by the control API\n")
+	return "Paused keepAlive and re-login."
+}
+
+func (ctl *controller) resume() string {
+	ctl.paused.Store(false)
+	poke(ctl.wake)
+	logger.Infof("Resumed by the control API\n")
+	return "Resumed keepAlive and re-login."
+}
+
+func (ctl *controller) reload(ctx context.Context, c *cli.Command) (string, error) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	return reloadSettings(ctx, c)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// replyAction writes the reply of an action, HTTP 500 if it failed
+func replyAction(w http.ResponseWriter, message string, err error) {
+	if err == nil {
+		writeJSON(w, http.StatusOK, controlReply{OK: true, Message: message})
+		return
+	}
+	reply := controlReply{Message: err.Error()}
+	var pe *libauth.PortalError
+	if errors.As(err, &pe) {
+		reply.Ecode = pe.Code
+	}
+	writeJSON(w, http.StatusInternalServerError, reply)
+}
+
+func (ctl *controller) handler(c *cli.Command) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, ctl.status(r.Context()))
+	})
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		message, err := ctl.login(r.Context())
+		replyAction(w, message, err)
+	})
+	mux.HandleFunc("POST /logout", func(w http.ResponseWriter, r *http.Request) {
+		message, err := ctl.logout(r.Context())
+		replyAction(w, message, err)
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		replyAction(w, ctl.pause(), nil)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		replyAction(w, ctl.resume(), nil)
+	})
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		message, err := ctl.reload(r.Context(), c)
+		replyAction(w, message, err)
+	})
+	return mux
+}
+
+// listenControlSocket listens on a Unix socket accessible by the owner
+// only, replacing the socket left by a previous run
+func listenControlSocket(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another auth-thu", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+var controlOnce sync.Once
+
+// serveControl sets up the controller for mode ("daemon" or "online")
+// and reloads the settings on SIGHUP, then starts the control API on
+// controlSocket and controlListen if either is set. It runs once and
+// stops when ctx is done.
+func serveControl(ctx context.Context, c *cli.Command, mode string) {
+	controlOnce.Do(func() {
+		s := snapshot()
+		control.mode = mode
+		reloadOnSighup(ctx, c)
+		if len(s.ControlSocket) == 0 && len(s.ControlListen) == 0 {
+			return
+		}
+		server := &http.Server{Handler: control.handler(c), ReadHeaderTimeout: 10 * time.Second}
+		serve := func(network, address string) {
+			var ln net.Listener
+			var err error
+			if network == "unix" {
+				ln, err = listenControlSocket(address)
+			} else {
+				ln, err = net.Listen(network, address)
+			}
+			if err != nil {
+				logger.Errorf("Control API failed: %s\n", err)
+				return
+			}
+			logger.Infof("Serving the control API on %s\n", address)
+			go server.Serve(ln)
+		}
+		if len(s.ControlSocket) != 0 {
+			serve("unix", s.ControlSocket)
+		}
+		if len(s.ControlListen) != 0 {
+			serve("tcp", s.ControlListen)
+		}
+		go func() {
+			<-ctx.Done()
+			server.Close()
+		}()
+	})
+}
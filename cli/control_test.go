@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeAuthServer is an auth server with one session, which the login and
+// logout requests bring online and offline. Logins fail with loginError
+// if it is set.
+type fakeAuthServer struct {
+	mu         sync.Mutex
+	online     bool
+	user       string
+	logins     int
+	loginError string
+}
+
+func (p *fakeAuthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var reply string
+	switch r.URL.Path {
+	case "/srun_portal_pc":
+		fmt.Fprint(w, `<script>var CONFIG = { ip     : "10.0.0.1", };</script>`)
+		return
+	case "/cgi-bin/get_challenge":
+		reply = `{"res":"ok","challenge":"0000000000000000000000000000000000000000000000000000000000000000","client_ip":"10.0.0.1"}`
+	case "/cgi-bin/srun_portal":
+		if r.URL.Query().Get("action") == "logout" {
+			p.online = false
+			reply = `{"error":"ok","ecode":0,"suc_msg":"logout_ok"}`
+		} else if p.online {
+			reply = `{"error":"login_error","ecode":"E2620","error_msg":"E2620: You are already online."}`
+		} else if len(p.loginError) != 0 {
+			reply = fmt.Sprintf(`{"error":"login_error","ecode":"%s","error_msg":"%s"}`, p.loginError, p.loginError)
+		} else {
+			p.online = true
+			p.user = r.URL.Query().Get("username")
+			p.logins++
+			reply = `{"error":"ok","ecode":0,"suc_msg":"login_ok","online_ip":"10.0.0.1"}`
+		}
+	case "/cgi-bin/rad_user_info":
+		reply = `{"error":"not_online_error"}`
+		if p.online {
+			reply = fmt.Sprintf(`{"error":"ok","user_name":"%s","online_ip":"10.0.0.1"}`, p.user)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprintf(w, "%s(%s)", r.URL.Query().Get("callback"), reply)
+}
+
+func (p *fakeAuthServer) state() (online bool, user string, logins int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.online, p.user, p.logins
+}
+
+// controlRequest sends a request to the control API and decodes its reply
+func controlRequest(h http.Handler, method, path string, reply any) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	if err := json.NewDecoder(w.Body).Decode(reply); err != nil {
+		panic(err)
+	}
+	return w.Code
+}
+
+func TestControlAPI(t *testing.T) {
+	portal := &fakeAuthServer{}
+	srv := httptest.NewServer(portal)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	cf := filepath.Join(home, "auth-thu.json")
+	writeConfig := func(username string, interval int) {
+		config := fmt.Sprintf(`{"username": "%s", "password": "pw", "host": "%s", "insecure": true, "acId": "1", "keyring": "none", "onlineInterval": %d}`, username, host, interval)
+		if err := os.WriteFile(cf, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("alice", 3)
+	c, err := runParseSettings("-c", cf, "online")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishSettings()
+	defer current.Store(nil)
+	control.mode = "online"
+	control.setState("online")
+	control.paused.Store(false)
+	h := control.handler(c)
+
+	Convey("GET /status should report the session", t, func() {
+		var st controlStatus
+		So(controlRequest(h, http.MethodGet, "/status", &st), ShouldEqual, http.StatusOK)
+		So(st.Mode, ShouldEqual, "online")
+		So(st.State, ShouldEqual, "online")
+		So(st.Username, ShouldEqual, "alice")
+		So(st.Session, ShouldNotBeNil)
+		So(st.Session.Online, ShouldBeFalse)
+	})
+
+	Convey("POST /login should log in and resume", t, func() {
+		control.paused.Store(true)
+		var reply controlReply
+		So(controlRequest(h, http.MethodPost, "/login", &reply), ShouldEqual, http.StatusOK)
+		So(reply.OK, ShouldBeTrue)
+		So(reply.Message, ShouldEqual, "Login Successfully!")
+		So(control.paused.Load(), ShouldBeFalse)
+		online, user, _ := portal.state()
+		So(online, ShouldBeTrue)
+		So(user, ShouldEqual, "alice")
+
+		So(controlRequest(h, http.MethodPost, "/login", &reply), ShouldEqual, http.StatusOK)
+		So(reply.Message, ShouldEqual, "Currently online!")
+
+		var st controlStatus
+		controlRequest(h, http.MethodGet, "/status", &st)
+		So(st.Session.Online, ShouldBeTrue)
+		So(st.Session.Username, ShouldEqual, "alice")
+	})
+
+	Convey("POST /pause and /resume should toggle paused", t, func() {
+		var reply controlReply
+		So(controlRequest(h, http.MethodPost, "/pause", &reply), ShouldEqual, http.StatusOK)
+		So(reply.OK, ShouldBeTrue)
+		So(control.paused.Load(), ShouldBeTrue)
+		var st controlStatus
+		controlRequest(h, http.MethodGet, "/status", &st)
+		So(st.Paused, ShouldBeTrue)
+
+		So(controlRequest(h, http.MethodPost, "/resume", &reply), ShouldEqual, http.StatusOK)
+		So(reply.OK, ShouldBeTrue)
+		So(control.paused.Load(), ShouldBeFalse)
+	})
+
+	Convey("POST /reload should apply the new settings", t, func() {
+		// Drain the signals of the previous actions
+		select {
+		case <-control.restart:
+		default:
+		}
+
+		var reply controlReply
+		So(controlRequest(h, http.MethodPost, "/reload", &reply), ShouldEqual, http.StatusOK)
+		So(reply.Message, ShouldEqual, "settings reloaded")
+
+		writeConfig("alice", 5)
+		So(controlRequest(h, http.MethodPost, "/reload", &reply), ShouldEqual, http.StatusOK)
+		So(reply.Message, ShouldEqual, "settings reloaded, keepalive restarted")
+		So(snapshot().OnIntrvl, ShouldEqual, 5)
+		So(len(control.restart), ShouldEqual, 1)
+		<-control.restart
+
+		_, _, logins := portal.state()
+		writeConfig("bob", 5)
+		So(controlRequest(h, http.MethodPost, "/reload", &reply), ShouldEqual, http.StatusOK)
+		So(reply.Message, ShouldEqual, "settings reloaded, logged in again")
+		online, user, after := portal.state()
+		So(online, ShouldBeTrue)
+		So(user, ShouldEqual, "bob")
+		So(after, ShouldEqual, logins+1)
+		<-control.restart
+		var st controlStatus
+		controlRequest(h, http.MethodGet, "/status", &st)
+		So(st.Username, ShouldEqual, "bob")
+
+		writeConfig("bob", 5000)
+		So(controlRequest(h, http.MethodPost, "/reload", &reply), ShouldEqual, http.StatusInternalServerError)
+		So(reply.OK, ShouldBeFalse)
+		So(reply.Message, ShouldContainSubstring, "onlineInterval")
+		So(snapshot().Username, ShouldEqual, "bob")
+		So(snapshot().OnIntrvl, ShouldEqual, 5)
+	})
+
+	Convey("POST /logout should log out and pause", t, func() {
+		var reply controlReply
+		So(controlRequest(h, http.MethodPost, "/logout", &reply), ShouldEqual, http.StatusOK)
+		So(reply.OK, ShouldBeTrue)
+		So(control.paused.Load(), ShouldBeTrue)
+		online, _, _ := portal.state()
+		So(online, ShouldBeFalse)
+
+		So(controlRequest(h, http.MethodPost, "/logout", &reply), ShouldEqual, http.StatusOK)
+		So(reply.Message, ShouldEqual, "Currently offline!")
+		control.paused.Store(false)
+	})
+
+	Convey("Unknown requests should be refused", t, func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+		So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+	})
+}
+
+func TestReloadPasswordFile(t *testing.T) {
+	portal := &fakeAuthServer{online: true, user: "alice"}
+	srv := httptest.NewServer(portal)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	pf := filepath.Join(home, "password")
+	writePassword := func(password string) {
+		if err := os.WriteFile(pf, []byte(password+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePassword("pw")
+	cf := filepath.Join(home, "auth-thu.json")
+	config := fmt.Sprintf(`{"username": "alice", "passwordFile": "%s", "host": "%s", "insecure": true, "acId": "1", "keyring": "none"}`, pf, host)
+	if err := os.WriteFile(cf, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := runParseSettings("-c", cf, "online")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishSettings()
+	defer current.Store(nil)
+	control.mode = "online"
+	control.paused.Store(false)
+
+	Convey("publishSettings should resolve the password of passwordFile", t, func() {
+		So(snapshot().Password, ShouldEqual, "pw")
+	})
+
+	Convey("Reloading an unchanged config with passwordFile should keep the session", t, func() {
+		message, err := reloadSettings(context.Background(), c)
+		So(err, ShouldBeNil)
+		So(message, ShouldEqual, "settings reloaded")
+		_, _, logins := portal.state()
+		So(logins, ShouldEqual, 0)
+	})
+
+	Convey("Reloading after the password file changed should log in again", t, func() {
+		writePassword("new")
+		message, err := reloadSettings(context.Background(), c)
+		So(err, ShouldBeNil)
+		So(message, ShouldEqual, "settings reloaded, logged in again")
+		_, _, logins := portal.state()
+		So(logins, ShouldEqual, 1)
+		So(snapshot().Password, ShouldEqual, "new")
+		<-control.restart
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/howeyc/gopass"
+	"github.com/urfave/cli/v3"
+
+	"github.com/z4yx/GoAuthing/keyring"
+)
+
+// keyringService is the "service" attribute of passwords in the keyring
+const keyringService = "auth-thu"
+
+// keyringFile returns the path of the encrypted file keyring of s
+func keyringFile(s *Settings) string {
+	if len(s.KeyringFile) != 0 {
+		return s.KeyringFile
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if len(dataHome) == 0 {
+		homedir, _ := os.UserHomeDir()
+		dataHome = path.Join(homedir, ".local", "share")
+	}
+	return path.Join(dataHome, "auth-thu", "credentials")
+}
+
+// keyringPassphrase returns the passphrase of the file keyring from
+// AUTH_THU_KEYRING_PASSPHRASE, or asks for it unless in daemon mode
+func keyringPassphrase(create, daemon bool) (string, error) {
+	if passphrase := os.Getenv(envPrefix + "KEYRING_PASSPHRASE"); len(passphrase) != 0 {
+		return passphrase, nil
+	}
+	if daemon {
+		return "", fmt.Errorf("%sKEYRING_PASSPHRASE is not set", envPrefix)
+	}
+	fmt.Printf("Keyring passphrase: ")
+	b, err := gopass.GetPasswdMasked()
+	if err != nil {
+		return "", fmt.Errorf("interrupted")
+	}
+	if create {
+		fmt.Printf("Repeat passphrase: ")
+		again, err := gopass.GetPasswdMasked()
+		if err != nil {
+			return "", fmt.Errorf("interrupted")
+		}
+		if string(again) != string(b) {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	return string(b), nil
+}
+
+// openKeyring opens the keyring chosen by s.Keyring: the Secret
+// Service, the encrypted file, or (by default) the Secret Service if it is
+// available and the file otherwise, including when nobody answers its
+// prompt to unlock. It returns nil if disabled.
+func openKeyring(s *Settings) (keyring.Store, error) {
+	passphrase := func(create bool) (string, error) {
+		return keyringPassphrase(create, s.Daemon)
+	}
+	file := &keyring.FileStore{Path: keyringFile(s), Passphrase: passphrase}
+	switch s.Keyring {
+	case "none":
+		return nil, nil
+	case "file":
+		return file, nil
+	case "secret-service":
+		return keyring.OpenSecretService(keyringService)
+	case "", "auto":
+		ss, err := keyring.OpenSecretService(keyringService)
+		if err != nil {
+			logger.Debugf("Secret Service unavailable, using %s: %s\n", file, err)
+			return file, nil
+		}
+		return keyring.WithFallback(ss, file), nil
+	}
+	return nil, fmt.Errorf("unknown keyring \"%s\"", s.Keyring)
+}
+
+// keyringPassword looks up the password of s.Username in the keyring,
+// returning an empty password if it is not found
+func keyringPassword(s *Settings) string {
+	if len(s.Username) == 0 {
+		return ""
+	}
+	store, err := openKeyring(s)
+	if store == nil || err != nil {
+		if err != nil {
+			logger.Debugf("Open keyring failed: %s\n", err)
+		}
+		return ""
+	}
+	password, err := store.Get(s.Username)
+	if err != nil {
+		if !errors.Is(err, keyring.ErrNotFound) {
+			logger.Errorf("Read password from %s failed: %s\n", store, err)
+		}
+		return ""
+	}
+	logger.Debugf("Read password from %s\n", store)
+	return password
+}
+
+// credentialsStore parses the settings and opens the keyring for the
+// credentials commands
+func credentialsStore(c *cli.Command) (keyring.Store, error) {
+	err := parseSettings(c)
+	if err != nil {
+		return nil, err
+	}
+	if err = requestUser(); err != nil {
+		return nil, err
+	}
+	store, err := openKeyring(&settings)
+	if err == nil && store == nil {
+		err = fmt.Errorf("keyring is disabled")
+	}
+	return store, err
+}
+
+func cmdCredentialsSet(ctx context.Context, c *cli.Command) error {
+	store, err := credentialsStore(c)
+	if err == nil && len(settings.Password) == 0 {
+		settings.Password, err = passwordFromSources(&settings)
+	}
+	if err == nil && len(settings.Password) == 0 && !settings.Daemon && !settings.PasswordStdin {
+		fmt.Printf("Password: ")
+		var b []byte
+		if b, err = gopass.GetPasswdMasked(); err != nil {
+			err = fmt.Errorf("interrupted")
+		}
+		settings.Password = string(b)
+	}
+	if err == nil && len(settings.Password) == 0 {
+		err = fmt.Errorf("password can't be empty")
+	}
+	if err == nil {
+		err = store.Set(settings.Username, settings.Password)
+	}
+	if err != nil {
+		logger.Errorf("Save credentials failed: %s\n", err)
+		exit(1)
+	}
+	logger.Infof("Saved the password of %s in %s\n", settings.Username, store)
+	return nil
+}
+
+func cmdCredentialsGet(ctx context.Context, c *cli.Command) error {
+	store, err := credentialsStore(c)
+	var password string
+	if err == nil {
+		password, err = store.Get(settings.Username)
+	}
+	if err != nil {
+		logger.Errorf("Read credentials failed: %s\n", err)
+		exit(1)
+	}
+	fmt.Println(password)
+	return nil
+}
+
+func cmdCredentialsDelete(ctx context.Context, c *cli.Command) error {
+	store, err := credentialsStore(c)
+	if err == nil {
+		err = store.Delete(settings.Username)
+	}
+	if err != nil {
+		logger.Errorf("Delete credentials failed: %s\n", err)
+		exit(1)
+	}
+	logger.Infof("Deleted the password of %s from %s\n", settings.Username, store)
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// controlClient returns the client and base URL of the control API of
+// the running auth-thu, found by the same settings
+func controlClient() (*http.Client, string, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch {
+	case len(settings.ControlSocket) != 0:
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", settings.ControlSocket)
+			},
+		}
+		return client, "http://auth-thu", nil
+	case len(settings.ControlListen) != 0:
+		return client, "http://" + settings.ControlListen, nil
+	}
+	return nil, "", fmt.Errorf("the control API is not enabled (set controlSocket or controlListen)")
+}
+
+// callControl sends a request to the control API and decodes the reply
+func callControl(ctx context.Context, method, path string, reply any) error {
+	client, base, err := controlClient()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, base+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot connect to auth-thu: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failed controlReply
+		if json.NewDecoder(resp.Body).Decode(&failed) != nil || len(failed.Message) == 0 {
+			return fmt.Errorf("HTTP status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("%s", failed.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+func cmdCtlStatus(ctx context.Context, c *cli.Command) error {
+	err := parseSettings(c)
+	if err == nil {
+		var st controlStatus
+		if err = callControl(ctx, http.MethodGet, "/status", &st); err == nil {
+			if c.Bool("json") {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				err = enc.Encode(st)
+			} else {
+				printControlStatus(&st)
+			}
+			if err == nil && (st.Session == nil || !st.Session.Online) {
+				exit(exitOffline)
+			}
+		}
+	}
+	if err != nil {
+		logger.Errorf("Ctl error: %s\n", err)
+		exit(1)
+	}
+	return nil
+}
+
+func printControlStatus(st *controlStatus) {
+	if st.State == st.Mode {
+		fmt.Printf("Mode:         %s\n", st.Mode)
+	} else {
+		fmt.Printf("Mode:         %s (%s)\n", st.Mode, st.State)
+	}
+	if st.Paused {
+		fmt.Printf("KeepAlive:    paused\n")
+	}
+	fmt.Printf("Uptime:       %s\n", time.Duration(st.UptimeSeconds)*time.Second)
+	if st.Session == nil {
+		fmt.Printf("Session:      unknown (%s)\n", st.Error)
+		return
+	}
+	printStatus(st.Session.userInfo())
+}
+
+// ctlAction returns the action of a ctl subcommand posting to path
+func ctlAction(path string) cli.ActionFunc {
+	return func(ctx context.Context, c *cli.Command) error {
+		err := parseSettings(c)
+		if err == nil {
+			var reply controlReply
+			if err = callControl(ctx, http.MethodPost, path, &reply); err == nil {
+				fmt.Println(reply.Message)
+			}
+		}
+		if err != nil {
+			logger.Errorf("Ctl error: %s\n", err)
+			exit(1)
+		}
+		return nil
+	}
+}
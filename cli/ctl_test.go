@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeControl serves the control API of a running auth-thu
+type fakeControl struct {
+	mu     sync.Mutex
+	online bool
+	failed bool
+}
+
+func (f *fakeControl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failed {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(controlReply{Message: "login failed"})
+		return
+	}
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(controlStatus{Mode: "online", State: "online", Session: &statusOutput{Online: f.online}})
+		return
+	}
+	json.NewEncoder(w).Encode(controlReply{OK: true, Message: "done"})
+}
+
+func TestCtlExitCode(t *testing.T) {
+	f := &fakeControl{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	listen := "--control-listen=" + strings.TrimPrefix(srv.URL, "http://")
+	set := func(online, failed bool) {
+		f.mu.Lock()
+		f.online, f.failed = online, failed
+		f.mu.Unlock()
+	}
+
+	Convey("ctl status should exit with exitOffline when offline", t, func() {
+		set(false, false)
+		So(runExit(t, listen, "ctl", "status", "--json"), ShouldEqual, exitOffline)
+	})
+
+	Convey("ctl status should succeed when online", t, func() {
+		set(true, false)
+		So(runExit(t, listen, "ctl", "status"), ShouldEqual, 0)
+	})
+
+	Convey("ctl should fail if the request fails", t, func() {
+		set(true, true)
+		So(runExit(t, listen, "ctl", "status"), ShouldEqual, 1)
+		So(runExit(t, listen, "ctl", "login"), ShouldEqual, 1)
+		set(true, false)
+		So(runExit(t, listen, "ctl", "login"), ShouldEqual, 0)
+	})
+
+	Convey("ctl should fail if the control API is not enabled", t, func() {
+		So(runExit(t, "ctl", "pause"), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+type daemonState int
+
+const (
+	// stateChecking queries the portal whether we are online
+	stateChecking daemonState = iota
+	// stateLoggingIn sends the login request
+	stateLoggingIn
+	// stateOnline runs keepalive and checks the session periodically
+	stateOnline
+	// stateWaiting backs off after a failure
+	stateWaiting
+	// statePaused waits for the control API to resume
+	statePaused
+)
+
+func (s daemonState) String() string {
+	switch s {
+	case stateChecking:
+		return "checking"
+	case stateLoggingIn:
+		return "logging in"
+	case stateOnline:
+		return "online"
+	case stateWaiting:
+		return "waiting"
+	case statePaused:
+		return "paused"
+	}
+	return fmt.Sprintf("daemonState(%d)", int(s))
+}
+
+// portalClient is the part of *libauth.Client used by the supervisor
+type portalClient interface {
+	Status(ctx context.Context) (*libauth.UserInfo, error)
+	UserInfo(ctx context.Context, ip string) (*libauth.UserInfo, error)
+	Login(ctx context.Context, username, password, anotherIP string) (*libauth.LoginResult, error)
+}
+
+// supervisor keeps the session online: it logs in when offline, runs
+// keepalive while online and starts over when the session drops
+type supervisor struct {
+	c        *cli.Command
+	client   portalClient
+	username string
+	state    daemonState
+	// wasOnline is set once the session has been online, so that logins
+	// after it dropped are reported as relogin
+	wasOnline bool
+	// failures is the number of consecutive failures, for backoff
+	failures int
+}
+
+// reset sets up the client and the user name from the settings, and
+// starts over by checking the session
+func (d *supervisor) reset(ctx context.Context) {
+	s := snapshot()
+	d.username = s.Username
+	if s.Campus {
+		d.username += "@tsinghua"
+	}
+	host, acID := portalParamsOf(ctx, s)
+	d.client = libauth.NewClient(host, acID)
+	d.setState(stateChecking)
+}
+
+// restart applies the reloaded settings
+func (d *supervisor) restart(ctx context.Context) {
+	logger.Infof("Restarting with the new settings\n")
+	d.failures = 0
+	d.reset(ctx)
+}
+
+func (d *supervisor) setState(s daemonState) {
+	if d.state != s {
+		logger.Debugf("Daemon state: %s -> %s\n", d.state, s)
+	}
+	d.state = s
+	control.setState(s.String())
+}
+
+// status queries the session of this machine, or of settings.Ip
+func (d *supervisor) status(ctx context.Context) (info *libauth.UserInfo, err error) {
+	if ip := snapshot().Ip; len(ip) != 0 {
+		info, err = d.client.UserInfo(ctx, ip)
+	} else {
+		info, err = d.client.Status(ctx)
+	}
+	if err == nil {
+		observeSession(info)
+	}
+	return
+}
+
+func (d *supervisor) check(ctx context.Context) {
+	info, err := d.status(ctx)
+	switch {
+	case err != nil:
+		// Treat as offline, as authUtil does. The login request tells
+		// us more if the portal is really unreachable.
+		logger.Debugf("Online check failed: %s\n", err)
+		d.setState(stateLoggingIn)
+	case info.Online:
+		logger.Infof("Currently online!")
+		d.failures = 0
+		d.setState(stateOnline)
+	default:
+		logger.Infof("Currently offline!")
+		d.setState(stateLoggingIn)
+	}
+}
+
+func (d *supervisor) login(ctx context.Context) error {
+	if control.paused.Load() {
+		d.setState(statePaused)
+		return nil
+	}
+	s := snapshot()
+	err := withRetry(ctx, "Login", func() error {
+		_, err := d.client.Login(ctx, d.username, s.Password, s.Ip)
+		return err
+	})
+	switch {
+	case err == nil:
+		logger.Infof("Login Successfully!\n")
+		action := actionLogin
+		if d.wasOnline {
+			action = actionRelogin
+		}
+		emitEvent(newHookEvent(action, d.username, s.Ip, nil))
+		d.failures = 0
+		d.setState(stateOnline)
+	case errors.Is(err, libauth.ErrAlreadyOnline):
+		d.setState(stateOnline)
+	case libauth.IsCredentialError(err):
+		emitEvent(newHookEvent(actionFailure, d.username, s.Ip, err))
+		return fmt.Errorf("Login Failed: %w", localize(err))
+	case errors.Is(err, libauth.ErrKickedOffline):
+		// e.g. E3008 (too many connections): the portal ended the session,
+		// which a login after the backoff may bring back
+		emitEvent(newHookEvent(actionFailure, d.username, s.Ip, err))
+		logger.Errorf("Kicked offline by the portal: %s\n", localize(err))
+		d.setState(stateWaiting)
+	default:
+		emitEvent(newHookEvent(actionFailure, d.username, s.Ip, err))
+		logger.Errorf("Login Failed: %s\n", localize(err))
+		d.setState(stateWaiting)
+	}
+	return nil
+}
+
+// online runs keepalive until it fails or the periodic check finds the
+// session dropped (e.g. kicked off by the portal with E3xxx/E4xxx)
+func (d *supervisor) online(ctx context.Context) {
+	d.wasOnline = true
+	s := snapshot()
+	kaCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	kaDone := make(chan error, 1)
+	if len(s.Ip) == 0 {
+		go func() { kaDone <- keepAliveLoop(kaCtx, d.c, s.Campus) }()
+	}
+
+	interval := time.Duration(s.CheckIntrvl) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-kaDone:
+			if err != nil {
+				logger.Infof("%s", err)
+			}
+			d.setState(stateChecking)
+			return
+		case <-ticker.C:
+			if !d.stillOnline(ctx) {
+				return
+			}
+		case <-control.wake:
+			if !d.stillOnline(ctx) {
+				return
+			}
+		case <-control.restart:
+			d.restart(ctx)
+			return
+		}
+	}
+}
+
+// stillOnline checks the session while online, and moves on to login
+// (or paused, after a logout by the control API) if it dropped
+func (d *supervisor) stillOnline(ctx context.Context) bool {
+	info, err := d.status(ctx)
+	if err != nil {
+		logger.Debugf("Online check failed: %s\n", err)
+		return true
+	}
+	if info.Online {
+		return true
+	}
+	if control.paused.Load() {
+		d.setState(statePaused)
+		return false
+	}
+	logger.Infof("Session dropped, logging in again\n")
+	emitEvent(newHookEvent(actionOffline, d.username, snapshot().Ip, nil))
+	d.setState(stateLoggingIn)
+	return false
+}
+
+// maxWaitDelay caps the backoff of the daemon if retryMaxBackoff is 0, so
+// that it comes back soon after a long outage
+const maxWaitDelay = 10 * time.Minute
+
+// backoff returns the delay before checking again after d.failures
+// failures, without jitter, and counts one more failure
+func (d *supervisor) backoff() time.Duration {
+	delay := retryDelay(d.failures)
+	if snapshot().RetryMaxBackoff == 0 && delay > maxWaitDelay {
+		delay = maxWaitDelay
+	}
+	if d.failures < 16 {
+		d.failures++
+	}
+	if delay < time.Second {
+		delay = time.Second
+	}
+	return delay
+}
+
+func (d *supervisor) wait(ctx context.Context) {
+	delay := jitter(d.backoff())
+	logger.Infof("Will try again in %v\n", delay)
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+		d.setState(stateChecking)
+	case <-control.wake:
+		d.setState(stateChecking)
+	case <-control.restart:
+		d.restart(ctx)
+	}
+}
+
+// pause waits until the control API logs in or resumes
+func (d *supervisor) pause(ctx context.Context) {
+	logger.Infof("Paused, waiting for login or resume\n")
+	select {
+	case <-ctx.Done():
+	case <-control.wake:
+		if !control.paused.Load() {
+			d.setState(stateChecking)
+		}
+	case <-control.restart:
+		d.restart(ctx)
+	}
+}
+
+// step runs the current state until it moves on. It fails if login
+// fails with an error that retrying cannot fix (e.g. wrong password).
+func (d *supervisor) step(ctx context.Context) error {
+	switch d.state {
+	case stateChecking:
+		d.check(ctx)
+	case stateLoggingIn:
+		return d.login(ctx)
+	case stateOnline:
+		d.online(ctx)
+	case stateWaiting:
+		d.wait(ctx)
+	case statePaused:
+		d.pause(ctx)
+	}
+	return nil
+}
+
+// run drives the state machine until ctx is done, or step fails
+func (d *supervisor) run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		if err := d.step(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdDaemon(ctx context.Context, c *cli.Command) error {
+	if c.Bool("all-profiles") {
+		setLoggerLevel(c.Bool("debug"), c.Bool("daemonize"))
+		if err := runAllProfiles(ctx, c); err != nil {
+			logger.Errorf("Daemon error: %s\n", err)
+			exit(1)
+		}
+		return nil
+	}
+	err := parseSettings(c)
+	if err != nil {
+		logger.Errorf("Parse setting error: %s\n", err)
+		exit(1)
+	}
+	if err = requestUser(); err == nil {
+		err = requestPasswd()
+	}
+	if err != nil {
+		logger.Errorf("Daemon error: %s\n", err)
+		exit(1)
+	}
+	publishSettings()
+	d := &supervisor{c: c}
+	d.reset(ctx)
+	serveMetrics(ctx, false)
+	serveControl(ctx, c, "daemon")
+
+	if err = d.run(ctx); err != nil {
+		logger.Errorf("Daemon error: %s\n", err)
+		exit(1)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+// fakePortal answers the online checks with online in turn (then the
+// last one), and the logins with logins in turn (then success)
+type fakePortal struct {
+	online []bool
+	logins []error
+	calls  int
+}
+
+func (p *fakePortal) Status(ctx context.Context) (*libauth.UserInfo, error) {
+	return p.UserInfo(ctx, "")
+}
+
+func (p *fakePortal) UserInfo(ctx context.Context, ip string) (*libauth.UserInfo, error) {
+	online := p.online[0]
+	if len(p.online) > 1 {
+		p.online = p.online[1:]
+	}
+	return &libauth.UserInfo{Online: online, Username: "user"}, nil
+}
+
+func (p *fakePortal) Login(ctx context.Context, username, password, anotherIP string) (*libauth.LoginResult, error) {
+	p.calls++
+	if len(p.logins) == 0 {
+		return &libauth.LoginResult{}, nil
+	}
+	err := p.logins[0]
+	p.logins = p.logins[1:]
+	return nil, err
+}
+
+func TestSupervisor(t *testing.T) {
+	ctx := context.Background()
+	// settings.Ip keeps online from starting keepAliveLoop
+	settings = Settings{Ip: "166.111.0.1", Password: "secret"}
+	control.paused.Store(false)
+
+	Convey("The supervisor should log in again when the session drops", t, func() {
+		portal := &fakePortal{online: []bool{true, false}}
+		d := &supervisor{client: portal, username: "user", state: stateChecking}
+
+		So(d.step(ctx), ShouldBeNil)
+		So(d.state, ShouldEqual, stateOnline)
+
+		// Check the session now instead of after checkInterval
+		poke(control.wake)
+		So(d.step(ctx), ShouldBeNil)
+		So(d.state, ShouldEqual, stateLoggingIn)
+		So(d.wasOnline, ShouldBeTrue)
+
+		So(d.step(ctx), ShouldBeNil)
+		So(d.state, ShouldEqual, stateOnline)
+		So(portal.calls, ShouldEqual, 1)
+	})
+
+	Convey("The supervisor should back off after a transient failure", t, func() {
+		portal := &fakePortal{online: []bool{false}, logins: []error{&libauth.PortalError{Code: "E2532"}}}
+		d := &supervisor{client: portal, username: "user", state: stateChecking}
+
+		So(d.step(ctx), ShouldBeNil)
+		So(d.state, ShouldEqual, stateLoggingIn)
+		So(d.step(ctx), ShouldBeNil)
+		So(d.state, ShouldEqual, stateWaiting)
+
+		// Skip the backoff delay
+		poke(control.wake)
+		So(d.step(ctx), ShouldBeNil)
+		So(d.state, ShouldEqual, stateChecking)
+		So(d.failures, ShouldEqual, 1)
+
+		So(d.step(ctx), ShouldBeNil)
+		So(d.step(ctx), ShouldBeNil)
+		So(d.state, ShouldEqual, stateOnline)
+		So(d.failures, ShouldEqual, 0)
+	})
+
+	Convey("The supervisor should exit on a non-retryable error", t, func() {
+		portal := &fakePortal{online: []bool{false}, logins: []error{&libauth.PortalError{Code: "E2553"}}}
+		d := &supervisor{client: portal, username: "user", state: stateLoggingIn}
+
+		err := d.step(ctx)
+		So(errors.Is(err, libauth.ErrWrongPassword), ShouldBeTrue)
+		So(portal.calls, ShouldEqual, 1)
+	})
+
+	Convey("The supervisor should back off after being kicked offline", t, func() {
+		portal := &fakePortal{online: []bool{false}, logins: []error{&libauth.PortalError{Code: "E3008"}}}
+		d := &supervisor{client: portal, username: "user", state: stateLoggingIn}
+
+		So(d.step(ctx), ShouldBeNil)
+		So(d.state, ShouldEqual, stateWaiting)
+		So(portal.calls, ShouldEqual, 1)
+	})
+
+	Convey("The supervisor should not log in while paused", t, func() {
+		portal := &fakePortal{online: []bool{false}}
+		d := &supervisor{client: portal, username: "user", state: stateLoggingIn}
+		control.paused.Store(true)
+		defer control.paused.Store(false)
+
+		So(d.step(ctx), ShouldBeNil)
+		So(d.state, ShouldEqual, statePaused)
+		So(portal.calls, ShouldEqual, 0)
+	})
+}
+
+func TestSupervisorBackoff(t *testing.T) {
+	Convey("The backoff should grow up to retryMaxBackoff", t, func() {
+		settings = Settings{RetryBackoff: 2, RetryMaxBackoff: 10}
+		d := &supervisor{}
+		delays := []time.Duration{}
+		for i := 0; i < 5; i++ {
+			delays = append(delays, d.backoff())
+		}
+		So(delays, ShouldResemble, []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second})
+	})
+
+	Convey("The backoff should be capped without retryMaxBackoff", t, func() {
+		settings = Settings{RetryBackoff: 2}
+		d := &supervisor{}
+		var delay time.Duration
+		for i := 0; i < 32; i++ {
+			delay = d.backoff()
+		}
+		So(delay, ShouldEqual, maxWaitDelay)
+		So(d.failures, ShouldEqual, 16)
+	})
+
+	Convey("The backoff should be at least a second", t, func() {
+		settings = Settings{}
+		So((&supervisor{}).backoff(), ShouldEqual, time.Second)
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+// familyAuth is the login/logout of one address family in dual-stack mode
+type familyAuth struct {
+	v6       bool
+	client   *libauth.Client
+	username string
+	// skip is set if the session is already in the desired state
+	skip bool
+	err  error
+}
+
+func (f *familyAuth) name() string {
+	if f.v6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// prepare probes ac_id and checks whether the family is online
+func (f *familyAuth) prepare(ctx context.Context, logout bool) {
+	domain := authDomains[0]
+	if f.v6 {
+		domain = authDomains[1]
+	}
+	acID := settings.AcID
+	if len(acID) == 0 {
+		var err error
+		acID, err = libauth.GetAcIDContext(ctx, f.v6)
+		if err != nil {
+			logger.Debugf("%s: Failed to get ac_id: %v", f.name(), err)
+			acID = "1"
+		}
+	}
+	f.client = libauth.NewClient(libauth.NewUrlProvider(domain, settings.Insecure), acID)
+	if settings.NoCheck {
+		return
+	}
+	info, err := f.client.Status(ctx)
+	if err != nil {
+		logger.Debugf("%s: Online check failed: %s\n", f.name(), err)
+		return
+	}
+	if info.Online && logout {
+		f.username = info.Username
+	}
+	if info.Online && !logout {
+		logger.Infof("%s: Currently online!", f.name())
+		f.skip = true
+	} else if !info.Online && logout {
+		logger.Infof("%s: Currently offline!", f.name())
+		f.skip = true
+	}
+}
+
+func (f *familyAuth) run(ctx context.Context, logout bool) {
+	action := "Login"
+	if logout {
+		action = "Logout"
+	}
+	f.err = withRetry(ctx, f.name()+" "+action, func() (err error) {
+		if logout {
+			_, err = f.client.Logout(ctx, f.username, "")
+		} else {
+			_, err = f.client.Login(ctx, f.username, settings.Password, "")
+		}
+		return
+	})
+	if f.err == nil {
+		logger.Infof("%s: %s Successfully!\n", f.name(), action)
+		emitEvent(newHookEvent(authAction(logout), f.username, "", nil))
+	} else {
+		emitEvent(newHookEvent(actionFailure, f.username, "", f.err))
+		f.err = fmt.Errorf("%s: %s Failed: %w", f.name(), action, localize(f.err))
+	}
+}
+
+// dualStackAuth logs in/out on auth4 and auth6 concurrently
+func dualStackAuth(ctx context.Context, c *cli.Command, logout bool) error {
+	families := []*familyAuth{{v6: false}, {v6: true}}
+	forEach := func(f func(*familyAuth)) {
+		var wg sync.WaitGroup
+		for _, fa := range families {
+			if fa.skip {
+				continue
+			}
+			wg.Add(1)
+			go func(fa *familyAuth) {
+				defer wg.Done()
+				f(fa)
+			}(fa)
+		}
+		wg.Wait()
+	}
+
+	forEach(func(fa *familyAuth) { fa.prepare(ctx, logout) })
+	if families[0].skip && families[1].skip {
+		if settings.KeepOn && !logout {
+			return keepAliveLoop(ctx, c, settings.Campus)
+		}
+		return nil
+	}
+
+	needUser := false
+	for _, fa := range families {
+		needUser = needUser || (!fa.skip && len(fa.username) == 0)
+	}
+	if needUser {
+		if err := requestUser(); err != nil {
+			return err
+		}
+	}
+	if !logout {
+		if err := requestPasswd(); err != nil {
+			return err
+		}
+	}
+	for _, fa := range families {
+		if len(fa.username) == 0 {
+			fa.username = settings.Username
+		}
+		if settings.Campus && !fa.v6 {
+			fa.username += "@tsinghua"
+		}
+	}
+
+	forEach(func(fa *familyAuth) { fa.run(ctx, logout) })
+	errs := []error{}
+	for _, fa := range families {
+		if fa.err != nil {
+			errs = append(errs, fa.err)
+		}
+	}
+	if len(errs) != 0 {
+		return errors.Join(errs...)
+	}
+	if settings.KeepOn && !logout {
+		return keepAliveLoop(ctx, c, settings.Campus)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDualStackAuth(t *testing.T) {
+	v4 := &fakeAuthServer{}
+	srv4 := httptest.NewServer(v4)
+	defer srv4.Close()
+	v6 := &fakeAuthServer{}
+	srv6 := httptest.NewServer(v6)
+	defer srv6.Close()
+
+	saved := authDomains
+	defer func() { authDomains = saved }()
+	useServers := func(v4Host, v6Host string) {
+		authDomains = [2]string{v4Host, v6Host}
+		for _, p := range []*fakeAuthServer{v4, v6} {
+			p.mu.Lock()
+			p.online, p.logins, p.loginError = false, 0, ""
+			p.mu.Unlock()
+		}
+	}
+	host4 := strings.TrimPrefix(srv4.URL, "http://")
+	host6 := strings.TrimPrefix(srv6.URL, "http://")
+	args := []string{"-u", "user", "-p", "pw", "auth", "--dual-stack", "--insecure", "--ac-id", "1"}
+
+	Convey("dualStackAuth should log in on both families", t, func() {
+		useServers(host4, host6)
+		So(runExit(t, args...), ShouldEqual, 0)
+		online4, user4, _ := v4.state()
+		online6, user6, _ := v6.state()
+		So(online4, ShouldBeTrue)
+		So(online6, ShouldBeTrue)
+		So(user4, ShouldEqual, "user")
+		So(user6, ShouldEqual, "user")
+	})
+
+	Convey("dualStackAuth should fail if IPv6 fails, after logging in on IPv4", t, func() {
+		useServers(host4, host6)
+		v6.mu.Lock()
+		v6.loginError = "E2553"
+		v6.mu.Unlock()
+		So(runExit(t, args...), ShouldEqual, 1)
+		online4, _, _ := v4.state()
+		online6, _, logins6 := v6.state()
+		So(online4, ShouldBeTrue)
+		So(online6, ShouldBeFalse)
+		So(logins6, ShouldEqual, 0)
+	})
+
+	Convey("dualStackAuth should fail if IPv6 is unsupported, after logging in on IPv4", t, func() {
+		// Nothing listens on port 1, as on a network without IPv6
+		useServers(host4, "127.0.0.1:1")
+		So(runExit(t, args...), ShouldEqual, 1)
+		online4, _, _ := v4.state()
+		So(online4, ShouldBeTrue)
+	})
+
+	Convey("dualStackAuth should fail if both families fail", t, func() {
+		useServers(host4, host6)
+		for _, p := range []*fakeAuthServer{v4, v6} {
+			p.mu.Lock()
+			p.loginError = "E2553"
+			p.mu.Unlock()
+		}
+		So(runExit(t, args...), ShouldEqual, 1)
+		online4, _, _ := v4.state()
+		online6, _, _ := v6.state()
+		So(online4, ShouldBeFalse)
+		So(online6, ShouldBeFalse)
+	})
+
+	Convey("dualStackAuth should skip the families already online", t, func() {
+		useServers(host4, host6)
+		v4.mu.Lock()
+		v4.online, v4.user = true, "user"
+		v4.mu.Unlock()
+		So(runExit(t, args...), ShouldEqual, 0)
+		_, _, logins4 := v4.state()
+		online6, _, logins6 := v6.state()
+		So(logins4, ShouldEqual, 0)
+		So(online6, ShouldBeTrue)
+		So(logins6, ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/z4yx/GoAuthing/notify"
+)
+
+// envPrefix is prepended to the names of the environment variables
+// overriding the settings, e.g. AUTH_THU_KEEP_ONLINE for "keepOnline"
+const envPrefix = "AUTH_THU_"
+
+// envName converts a config key (e.g. "keepAliveV6Interval" or
+// "hook-success") to its environment variable name
+func envName(key string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	prev := rune(0)
+	for _, r := range key {
+		switch {
+		case r == '-':
+			r = '_'
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+		prev = r
+	}
+	return b.String()
+}
+
+// applyEnvSettings overrides the settings of the config file with the
+// AUTH_THU_* environment variables, and returns how many were applied
+func applyEnvSettings(s *Settings) (applied int, err error) {
+	v := reflect.ValueOf(s).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		name := envName(key)
+		value, exist := os.LookupEnv(name)
+		if !exist {
+			continue
+		}
+		if err = setFromEnv(v.Field(i), value); err != nil {
+			return applied, fmt.Errorf("invalid %s=\"%s\" (%s)", name, value, err)
+		}
+		logger.Debugf("Setting \"%s\" from %s\n", key, name)
+		applied++
+	}
+	return
+}
+
+func setFromEnv(field reflect.Value, value string) error {
+	switch p := field.Addr().Interface().(type) {
+	case *string:
+		*p = value
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*p = f
+	case *[]ProbeTarget:
+		// Comma-separated, as --keepalive-target
+		*p = nil
+		for _, spec := range strings.Split(value, ",") {
+			if spec = strings.TrimSpace(spec); len(spec) == 0 {
+				continue
+			}
+			target, err := parseProbeTarget(spec)
+			if err != nil {
+				return err
+			}
+			*p = append(*p, target)
+		}
+	case *[]notify.Webhook:
+		// Comma-separated URLs, as --webhook
+		*p = nil
+		for _, url := range strings.Split(value, ",") {
+			if url = strings.TrimSpace(url); len(url) != 0 {
+				*p = append(*p, notify.Webhook{URL: url})
+			}
+		}
+	default:
+		return fmt.Errorf("not supported in environment variables")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/urfave/cli/v3"
+)
+
+// runParseSettings parses the settings of the command line args (without
+// the program name) as the online command does, from scratch
+func runParseSettings(args ...string) (c *cli.Command, err error) {
+	settings = Settings{}
+	current.Store(nil)
+	app := newApp()
+	for _, sub := range app.Commands {
+		if sub.Name == "online" {
+			sub.Action = func(ctx context.Context, cmd *cli.Command) error {
+				c = cmd
+				return parseSettings(cmd)
+			}
+		}
+	}
+	err = app.Run(context.Background(), append([]string{"auth-thu"}, args...))
+	return
+}
+
+func TestEnvName(t *testing.T) {
+	Convey("envName should map the config keys to upper snake case", t, func() {
+		cases := map[string]string{
+			"username":            "AUTH_THU_USERNAME",
+			"keepOnline":          "AUTH_THU_KEEP_ONLINE",
+			"keepAliveV6Interval": "AUTH_THU_KEEP_ALIVE_V6_INTERVAL",
+			"useV6":               "AUTH_THU_USE_V6",
+			"acId":                "AUTH_THU_AC_ID",
+			"hook-success":        "AUTH_THU_HOOK_SUCCESS",
+			"hook-keepalive-lost": "AUTH_THU_HOOK_KEEPALIVE_LOST",
+		}
+		for key, name := range cases {
+			So(envName(key), ShouldEqual, name)
+		}
+	})
+}
+
+func TestApplyEnvSettings(t *testing.T) {
+	Convey("applyEnvSettings should parse comma-separated keepalive targets", t, func() {
+		t.Setenv("AUTH_THU_KEEP_ALIVE_TARGETS", "tcp://166.111.4.100:443, ,dns://www.tsinghua.edu.cn@166.111.8.28:53")
+		var s Settings
+		applied, err := applyEnvSettings(&s)
+		So(err, ShouldBeNil)
+		So(applied, ShouldEqual, 1)
+		So(s.KeepAliveTargets, ShouldResemble, []ProbeTarget{
+			{Type: "tcp", Address: "166.111.4.100:443"},
+			{Type: "dns", Address: "www.tsinghua.edu.cn", Server: "166.111.8.28:53"},
+		})
+	})
+
+	Convey("applyEnvSettings should reject invalid values", t, func() {
+		t.Setenv("AUTH_THU_KEEP_ONLINE", "sometimes")
+		var s Settings
+		_, err := applyEnvSettings(&s)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "AUTH_THU_KEEP_ONLINE")
+	})
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	// Keep the config files of the user out
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	cf := filepath.Join(home, "auth-thu.json")
+	if err := os.WriteFile(cf, []byte(`{"username": "file", "onlineInterval": 5}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Flags should override the environment, the config file and the defaults", t, func() {
+		_, err := runParseSettings("online")
+		So(err, ShouldBeNil)
+		So(settings.Username, ShouldEqual, "")
+		So(settings.OnIntrvl, ShouldEqual, 3)
+
+		_, err = runParseSettings("-c", cf, "online")
+		So(err, ShouldBeNil)
+		So(settings.Username, ShouldEqual, "file")
+		So(settings.OnIntrvl, ShouldEqual, 5)
+
+		t.Setenv("AUTH_THU_USERNAME", "env")
+		t.Setenv("AUTH_THU_ONLINE_INTERVAL", "7")
+		_, err = runParseSettings("-c", cf, "online")
+		So(err, ShouldBeNil)
+		So(settings.Username, ShouldEqual, "env")
+		So(settings.OnIntrvl, ShouldEqual, 7)
+
+		_, err = runParseSettings("-c", cf, "-u", "flag", "--online-interval", "9", "online")
+		So(err, ShouldBeNil)
+		So(settings.Username, ShouldEqual, "flag")
+		So(settings.OnIntrvl, ShouldEqual, 9)
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/z4yx/GoAuthing/libauth"
+	"github.com/z4yx/GoAuthing/notify"
+)
+
+// Actions of the hooks, passed to them in AUTH_THU_ACTION
+const (
+	actionLogin         = "login"
+	actionRelogin       = "relogin"
+	actionLogout        = "logout"
+	actionFailure       = "failure"
+	actionOffline       = "offline-detected"
+	actionKeepAliveLost = "keepalive-lost"
+)
+
+// hookEvent is what happened, passed to the hooks in environment variables
+type hookEvent struct {
+	Action   string
+	Username string
+	IP       string
+	// Ecode and Message describe the error of failure and keepalive-lost
+	Ecode   string
+	Message string
+}
+
+// newHookEvent returns the event of an action of username at ip (empty for
+// this machine), with the error if it failed
+func newHookEvent(action, username, ip string, err error) hookEvent {
+	ev := hookEvent{Action: action, Username: username, IP: ip}
+	if err != nil {
+		ev.Message = err.Error()
+		var pe *libauth.PortalError
+		if errors.As(err, &pe) {
+			ev.Ecode = pe.Code
+			ev.Message = pe.Localize(snapshot().Lang).Message
+		}
+	}
+	return ev
+}
+
+func (ev hookEvent) environ() []string {
+	return append(os.Environ(),
+		envPrefix+"ACTION="+ev.Action,
+		envPrefix+"USERNAME="+ev.Username,
+		envPrefix+"IP="+ev.IP,
+		envPrefix+"ECODE="+ev.Ecode,
+		envPrefix+"MESSAGE="+ev.Message,
+	)
+}
+
+// authAction returns the action of a successful login/logout
+func authAction(logout bool) string {
+	if logout {
+		return actionLogout
+	}
+	return actionLogin
+}
+
+// hookCommands returns the hooks of s to run for an action. hook-success
+// runs after both login and logout, for backward-compatibility.
+func hookCommands(action string, s *Settings) []string {
+	switch action {
+	case actionLogin, actionRelogin:
+		return []string{s.HookLogin, s.HookSucc}
+	case actionLogout:
+		return []string{s.HookLogout, s.HookSucc}
+	case actionFailure:
+		return []string{s.HookFailure}
+	case actionOffline:
+		return []string{s.HookOffline}
+	case actionKeepAliveLost:
+		return []string{s.HookKaLost}
+	}
+	return nil
+}
+
+// defaultHookTimeout is the time hooks may run before being killed (s)
+const defaultHookTimeout = 60
+
+// Events are dispatched one at a time, in order, by a goroutine, so that
+// slow hooks and webhook retries don't hold up keepAlive or the daemon
+var (
+	eventQueue   = make(chan queuedEvent, 64)
+	eventsOnce   sync.Once
+	eventsQueued sync.WaitGroup
+)
+
+// queuedEvent is an event with the settings it was emitted with
+type queuedEvent struct {
+	ev hookEvent
+	s  Settings
+}
+
+// emitEvent queues ev to run its hooks and post it to the webhooks
+func emitEvent(ev hookEvent) {
+	eventsOnce.Do(func() {
+		go func() {
+			for q := range eventQueue {
+				runHooks(q.ev, &q.s)
+				notifyWebhooks(q.ev, &q.s)
+				eventsQueued.Done()
+			}
+		}()
+	})
+	eventsQueued.Add(1)
+	select {
+	case eventQueue <- queuedEvent{ev: ev, s: *snapshot()}:
+	default:
+		// Don't hold up the caller behind a backlog of slow hooks
+		eventsQueued.Done()
+		logger.Warningf("Too many events queued, dropped %s event\n", ev.Action)
+	}
+}
+
+// flushEvents waits until the queued events have been dispatched
+func flushEvents() {
+	eventsQueued.Wait()
+}
+
+// osExit is os.Exit, replaced by the tests to check the exit code
+var osExit = os.Exit
+
+// exit flushes the events, then exits with code
+func exit(code int) {
+	flushEvents()
+	osExit(code)
+}
+
+// notifyWebhooks posts ev to the webhooks of s wanting it
+func notifyWebhooks(ev hookEvent, s *Settings) {
+	if len(s.Webhooks) == 0 {
+		return
+	}
+	hostname, _ := os.Hostname()
+	n := &notify.Notifier{Webhooks: s.Webhooks, RetryDelay: time.Second}
+	err := n.Notify(context.Background(), notify.Event{
+		Action:   ev.Action,
+		Username: ev.Username,
+		IP:       ev.IP,
+		Ecode:    ev.Ecode,
+		Message:  ev.Message,
+		Hostname: hostname,
+		Time:     time.Now(),
+	})
+	if err != nil {
+		logger.Errorf("Webhook notification failed: %s\n", err)
+	}
+}
+
+// runHooks runs the hooks of ev in s one by one
+func runHooks(ev hookEvent, s *Settings) {
+	for _, command := range hookCommands(ev.Action, s) {
+		if len(command) != 0 {
+			runHook(command, ev, s)
+		}
+	}
+}
+
+// runHook runs a hook, killing it after s.HookTimeout seconds
+func runHook(command string, ev hookEvent, s *Settings) {
+	logger.Debugf("Run %s hook \"%s\"\n", ev.Action, command)
+	timeout := s.HookTimeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	var cmd *exec.Cmd
+	switch {
+	case !s.HookShell:
+		cmd = exec.CommandContext(ctx, command)
+	case runtime.GOOS == "windows":
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	default:
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = ev.environ()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); ctx.Err() == context.DeadlineExceeded {
+		logger.Errorf("Hook \"%s\" timed out after %ds\n", command, timeout)
+	} else if err != nil {
+		logger.Errorf("Hook execution failed: %v\n", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+func TestHookCommands(t *testing.T) {
+	Convey("hookCommands should run hook-success after both login and logout", t, func() {
+		s := &Settings{HookLogin: "in", HookLogout: "out", HookSucc: "succ", HookFailure: "fail"}
+		So(hookCommands(actionLogin, s), ShouldResemble, []string{"in", "succ"})
+		So(hookCommands(actionRelogin, s), ShouldResemble, []string{"in", "succ"})
+		So(hookCommands(actionLogout, s), ShouldResemble, []string{"out", "succ"})
+		So(hookCommands(actionFailure, s), ShouldResemble, []string{"fail"})
+		So(hookCommands("unknown", s), ShouldBeEmpty)
+	})
+}
+
+func TestNewHookEvent(t *testing.T) {
+	Convey("newHookEvent should take the ecode of portal errors", t, func() {
+		ev := newHookEvent(actionFailure, "user", "166.111.0.1", &libauth.PortalError{Code: "E2553", Message: "密码错误"})
+		So(ev.Ecode, ShouldEqual, "E2553")
+		So(ev.Message, ShouldNotBeEmpty)
+		ev = newHookEvent(actionLogin, "user", "", nil)
+		So(ev.Ecode, ShouldBeEmpty)
+		So(ev.Message, ShouldBeEmpty)
+	})
+}
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks need sh")
+	}
+	ev := hookEvent{Action: actionFailure, Username: "user", IP: "166.111.0.1", Ecode: "E2553", Message: "wrong password"}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "env.txt")
+
+	Convey("runHook should pass the event in AUTH_THU_* to the program", t, func() {
+		script := filepath.Join(dir, "hook.sh")
+		err := os.WriteFile(script, []byte("#!/bin/sh\nenv > \""+out+"\"\n"), 0o755)
+		So(err, ShouldBeNil)
+		runHook(script, ev, &Settings{})
+		env, err := os.ReadFile(out)
+		So(err, ShouldBeNil)
+		for _, v := range []string{
+			"AUTH_THU_ACTION=failure",
+			"AUTH_THU_USERNAME=user",
+			"AUTH_THU_IP=166.111.0.1",
+			"AUTH_THU_ECODE=E2553",
+			"AUTH_THU_MESSAGE=wrong password",
+		} {
+			So(strings.Split(string(env), "\n"), ShouldContain, v)
+		}
+	})
+
+	Convey("runHook should run command lines in the shell with hookShell", t, func() {
+		command := "echo \"$AUTH_THU_ACTION $AUTH_THU_ECODE\" > \"" + out + "\""
+		runHook(command, ev, &Settings{HookShell: true})
+		b, err := os.ReadFile(out)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "failure E2553\n")
+
+		// Without hookShell the command line is taken as a path
+		So(os.Remove(out), ShouldBeNil)
+		runHook(command, ev, &Settings{})
+		_, err = os.Stat(out)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("runHook should kill hooks running longer than hookTimeout", t, func() {
+		start := time.Now()
+		runHook("exec sleep 10", ev, &Settings{HookShell: true, HookTimeout: 1})
+		So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+	})
+}
+
+func TestHookTimeoutSetting(t *testing.T) {
+	Convey("hookTimeout should default to a finite time", t, func() {
+		_, err := runParseSettings("online")
+		So(err, ShouldBeNil)
+		So(settings.HookTimeout, ShouldEqual, defaultHookTimeout)
+		_, err = runParseSettings("--hook-timeout", "5", "online")
+		So(err, ShouldBeNil)
+		So(settings.HookTimeout, ShouldEqual, 5)
+	})
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// KeepAliveProbe generates traffic through the gateway to keep the
+// session online, and tells if the Internet is still reachable
+type KeepAliveProbe interface {
+	Probe(ctx context.Context) error
+	String() string
+}
+
+// ProbeTarget configures a KeepAliveProbe in the config file
+type ProbeTarget struct {
+	// Type is one of "http", "tcp", "dns" and "udp"
+	Type string `json:"type"`
+	// Address is the URL for http, host:port for tcp/udp and the name to
+	// look up for dns
+	Address string `json:"address"`
+	// Method is GET or HEAD (default) for http
+	Method string `json:"method,omitempty"`
+	// Server is the host:port of the DNS server for dns, default is the
+	// system resolver
+	Server string `json:"server,omitempty"`
+	// ExpectStatus lists the accepted HTTP status codes, any if empty
+	ExpectStatus []int `json:"expectStatus,omitempty"`
+	// Interval is the interval between probes (s), default onlineInterval
+	Interval int `json:"interval,omitempty"`
+	// Family is "v4" or "v6", default depends on useV6
+	Family string `json:"family,omitempty"`
+}
+
+const (
+	probeTimeout = 10 * time.Second
+	dialTimeout  = 6 * time.Second
+)
+
+// defaultV6Interval is the interval of the background IPv6 keepalive (s)
+const defaultV6Interval = 13 * 60
+
+func dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:       dialTimeout,
+		KeepAlive:     0,
+		FallbackDelay: -1, // disable RFC 6555 Fast Fallback
+	}
+}
+
+type httpProbe struct {
+	url, method string
+	expect      []int
+	client      *http.Client
+}
+
+func newHTTPProbe(target, method, network string, expect []int) *httpProbe {
+	return &httpProbe{
+		url:    target,
+		method: method,
+		expect: expect,
+		client: &http.Client{
+			Timeout: probeTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _network, addr string) (net.Conn, error) {
+					logger.Debugf("DialContext %s (%s)\n", addr, network)
+					return dialer().DialContext(ctx, network, addr)
+				},
+			},
+		},
+	}
+}
+
+func (p *httpProbe) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, p.method, p.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	logger.Debugf("HTTP status code %d\n", resp.StatusCode)
+	if len(p.expect) == 0 {
+		return nil
+	}
+	for _, code := range p.expect {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected HTTP status code %d", resp.StatusCode)
+}
+
+func (p *httpProbe) String() string {
+	return p.method + " " + p.url
+}
+
+type tcpProbe struct {
+	addr, network string
+}
+
+func (p *tcpProbe) Probe(ctx context.Context) error {
+	conn, err := dialer().DialContext(ctx, p.network, p.addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p *tcpProbe) String() string {
+	return p.network + "://" + p.addr
+}
+
+// udpProbe sends a datagram without expecting a reply. It only fails if
+// sending fails or the peer refuses it, so no ICMP echo is needed.
+type udpProbe struct {
+	addr, network string
+}
+
+func (p *udpProbe) Probe(ctx context.Context) error {
+	conn, err := dialer().DialContext(ctx, p.network, p.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("auth-thu keepalive\n")); err != nil {
+		return err
+	}
+	// Wait shortly to catch a port unreachable error
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 512))
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return nil
+	}
+	return err
+}
+
+func (p *udpProbe) String() string {
+	return p.network + "://" + p.addr
+}
+
+type dnsProbe struct {
+	name     string
+	resolver *net.Resolver
+	network  string
+}
+
+func newDNSProbe(name, server, family string) *dnsProbe {
+	p := &dnsProbe{name: name, network: "ip4"}
+	if family == "v6" {
+		p.network = "ip6"
+	}
+	p.resolver = net.DefaultResolver
+	if server != "" {
+		p.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _address string) (net.Conn, error) {
+				if family == "v6" {
+					network = strings.Replace(network, "udp", "udp6", 1)
+					network = strings.Replace(network, "tcp", "tcp6", 1)
+				} else {
+					network = strings.Replace(network, "udp", "udp4", 1)
+					network = strings.Replace(network, "tcp", "tcp4", 1)
+				}
+				return dialer().DialContext(ctx, network, server)
+			},
+		}
+	}
+	return p
+}
+
+func (p *dnsProbe) Probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	addrs, err := p.resolver.LookupIP(ctx, p.network, p.name)
+	if err == nil {
+		logger.Debugf("%s resolved to %v\n", p.name, addrs)
+	}
+	return err
+}
+
+func (p *dnsProbe) String() string {
+	return "dns://" + p.name
+}
+
+// newProbe creates the probe described by t, of IPv6 by default if v6
+func newProbe(t ProbeTarget, v6 bool) (KeepAliveProbe, error) {
+	family := targetFamily(t, v6)
+	suffix := map[string]string{"v4": "4", "v6": "6"}[family]
+	if suffix == "" {
+		return nil, fmt.Errorf("invalid family \"%s\" of keepalive target %s", t.Family, t.Address)
+	}
+
+	for _, code := range t.ExpectStatus {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid expectStatus %d of keepalive target %s", code, t.Address)
+		}
+	}
+	if len(t.ExpectStatus) != 0 && !strings.HasPrefix(strings.ToLower(t.Type), "http") {
+		return nil, fmt.Errorf("expectStatus of keepalive target %s is only valid for http", t.Address)
+	}
+
+	switch strings.ToLower(t.Type) {
+	case "http", "https":
+		method := strings.ToUpper(t.Method)
+		if method == "" {
+			method = http.MethodHead
+		}
+		if method != http.MethodHead && method != http.MethodGet {
+			return nil, fmt.Errorf("invalid method \"%s\" of keepalive target %s", t.Method, t.Address)
+		}
+		return newHTTPProbe(t.Address, method, "tcp"+suffix, t.ExpectStatus), nil
+	case "tcp":
+		return &tcpProbe{addr: t.Address, network: "tcp" + suffix}, nil
+	case "udp":
+		return &udpProbe{addr: t.Address, network: "udp" + suffix}, nil
+	case "dns":
+		return newDNSProbe(t.Address, t.Server, family), nil
+	}
+	return nil, fmt.Errorf("invalid type \"%s\" of keepalive target %s", t.Type, t.Address)
+}
+
+// parseProbeTarget parses the --keepalive-target flag, i.e.
+// http(s)://..., tcp://host:port, udp://host:port or dns://name[@server]
+func parseProbeTarget(spec string) (t ProbeTarget, err error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return
+	}
+	switch u.Scheme {
+	case "http", "https":
+		t = ProbeTarget{Type: "http", Address: spec}
+	case "tcp", "udp":
+		t = ProbeTarget{Type: u.Scheme, Address: u.Host}
+	case "dns":
+		t = ProbeTarget{Type: "dns", Address: u.Host}
+		if i := strings.Index(spec, "@"); i >= 0 {
+			t.Address = spec[len("dns://"):i]
+			t.Server = spec[i+1:]
+		}
+	default:
+		err = fmt.Errorf("invalid keepalive target \"%s\"", spec)
+	}
+	return
+}
+
+// defaultProbeTargets is what auth-thu probes without configured targets
+func defaultProbeTargets(s *Settings, campusOnly bool) []ProbeTarget {
+	target := "https://www.baidu.com/"
+	if campusOnly || s.V6 {
+		target = "https://www.tsinghua.edu.cn/"
+	}
+	return []ProbeTarget{{Type: "http", Address: target}}
+}
+
+// keepAliveProbe is a probe run by keepAliveLoop
+type keepAliveProbe struct {
+	KeepAliveProbe
+	family   string
+	interval time.Duration
+	// critical probes make keepAliveLoop fail when they all fail,
+	// others are only reported (e.g. the background IPv6 probe)
+	critical bool
+	// errorCount is the number of consecutive failures
+	errorCount int
+}
+
+// targetFamily returns the address family probed by t, IPv6 by default
+// if v6
+func targetFamily(t ProbeTarget, v6 bool) string {
+	if t.Family != "" {
+		return t.Family
+	}
+	if v6 {
+		return "v6"
+	}
+	return "v4"
+}
+
+// keepAliveProbes creates the probes of the main leg (the configured
+// targets, probed every onlineInterval by default) and, unless the main leg
+// is IPv6 already, the background IPv6 leg
+func keepAliveProbes(s *Settings, campusOnly bool) (probes []*keepAliveProbe, err error) {
+	targets := s.KeepAliveTargets
+	if len(targets) == 0 {
+		targets = defaultProbeTargets(s, campusOnly)
+	}
+	for _, t := range targets {
+		p := &keepAliveProbe{
+			family:   targetFamily(t, s.V6),
+			interval: time.Duration(t.Interval) * time.Second,
+			critical: true,
+		}
+		if p.interval <= 0 {
+			p.interval = time.Duration(s.OnIntrvl) * time.Second
+		}
+		if p.KeepAliveProbe, err = newProbe(t, s.V6); err != nil {
+			return
+		}
+		probes = append(probes, p)
+	}
+
+	if !s.V6 && s.V6Intrvl > 0 {
+		t := ProbeTarget{Type: "http", Address: "https://www.tsinghua.edu.cn/"}
+		if s.V6Target != "" {
+			if t, err = parseProbeTarget(s.V6Target); err != nil {
+				return
+			}
+		}
+		t.Family = "v6"
+		p := &keepAliveProbe{
+			family:   "v6",
+			interval: time.Duration(s.V6Intrvl) * time.Second,
+		}
+		if p.KeepAliveProbe, err = newProbe(t, s.V6); err != nil {
+			return
+		}
+		probes = append(probes, p)
+	}
+	return
+}
+
+type probeResult struct {
+	index int
+	err   error
+}
+
+// runProbe probes periodically until ctx is done. Non-critical probes
+// wait for an interval first, as they run alongside the main leg.
+func runProbe(ctx context.Context, index int, probe *keepAliveProbe, results chan<- probeResult) {
+	if !probe.critical {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(probe.interval):
+		}
+	}
+	for {
+		if !control.paused.Load() {
+			start := time.Now()
+			err := probe.Probe(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			observeProbe(probe, time.Since(start), err)
+			select {
+			case <-ctx.Done():
+				return
+			case results <- probeResult{index, err}:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(probe.interval):
+		}
+	}
+}
+
+// familyDown tells if every probe of family has failed onRetry times in
+// a row. Families without probes are never down.
+func familyDown(probes []*keepAliveProbe, family string, onRetry int) bool {
+	found := false
+	for _, p := range probes {
+		if p.family == family {
+			found = true
+			if p.errorCount < onRetry {
+				return false
+			}
+		}
+	}
+	return found
+}
+
+// allCriticalFailed tells if every critical probe has failed onRetry
+// times in a row, which makes keepAliveLoop fail
+func allCriticalFailed(probes []*keepAliveProbe, onRetry int) bool {
+	for _, p := range probes {
+		if p.critical && p.errorCount < onRetry {
+			return false
+		}
+	}
+	return true
+}
+
+func familyName(family string) string {
+	if family == "v6" {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// keepAliveLoop probes until all critical probes fail or ctx is done,
+// restarting with the new settings after a reload
+func keepAliveLoop(ctx context.Context, c *cli.Command, campusOnly bool) error {
+	logger.Infof("Accessing websites periodically to keep you online")
+	publishSettings()
+	for {
+		restarted, err := keepAlive(ctx, c, campusOnly)
+		if !restarted {
+			return err
+		}
+		logger.Infof("Restarting keepAlive with the new settings\n")
+	}
+}
+
+// keepAlive runs the probes of the current settings until they fail, ctx
+// is done, or the control API asks to restart (restarted is set then)
+func keepAlive(ctx context.Context, c *cli.Command, campusOnly bool) (restarted bool, ret error) {
+	s := snapshot()
+	probes, ret := keepAliveProbes(s, campusOnly)
+	if ret != nil {
+		return
+	}
+	serveMetrics(ctx, true)
+	serveControl(ctx, c, "online")
+	if control.mode == "online" {
+		control.setState("online")
+	}
+
+	// The daemon restarts the whole online state instead
+	restart := control.restart
+	if control.mode == "daemon" {
+		restart = nil
+	}
+
+	// All probes stop when keepAlive returns
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Consumes ~5MB per day when settings.OnIntrvl == 3
+	results := make(chan probeResult)
+	for i, probe := range probes {
+		go runProbe(ctx, i, probe, results)
+	}
+
+	down := map[string]bool{}
+	for {
+		var r probeResult
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-restart:
+			return true, nil
+		case r = <-results:
+		}
+		probe := probes[r.index]
+		if r.err == nil {
+			probe.errorCount = 0
+		} else {
+			probe.errorCount++
+		}
+
+		// Report the families whose path went down or came back
+		for _, family := range []string{"v4", "v6"} {
+			if isDown := familyDown(probes, family, s.OnRetry); isDown != down[family] {
+				down[family] = isDown
+				if isDown {
+					logger.Warningf("%s keepAlive path is down: %s\n", familyName(family), r.err)
+				} else {
+					logger.Infof("%s keepAlive path is up again\n", familyName(family))
+				}
+			}
+		}
+		if r.err == nil {
+			continue
+		}
+
+		if allCriticalFailed(probes, s.OnRetry) {
+			emitEvent(newHookEvent(actionKeepAliveLost, s.Username, "", r.err))
+			return false, fmt.Errorf("keepAlive request error (re-login might be required): %w\n", r.err)
+		}
+		if down[probe.family] {
+			// Already reported above
+			logger.Debugf("keepAlive request error (%s): %s\n", probe, r.err)
+		} else {
+			logger.Infof("keepAlive request error (%s, will retry): %s\n", probe, r.err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseProbeTarget(t *testing.T) {
+	Convey("parseProbeTarget should parse every scheme", t, func() {
+		cases := map[string]ProbeTarget{
+			"https://www.tsinghua.edu.cn/":              {Type: "http", Address: "https://www.tsinghua.edu.cn/"},
+			"http://example.com/generate":               {Type: "http", Address: "http://example.com/generate"},
+			"tcp://166.111.4.100:443":                   {Type: "tcp", Address: "166.111.4.100:443"},
+			"udp://[2402:f000::1]:53":                   {Type: "udp", Address: "[2402:f000::1]:53"},
+			"dns://www.tsinghua.edu.cn":                 {Type: "dns", Address: "www.tsinghua.edu.cn"},
+			"dns://www.tsinghua.edu.cn@166.111.8.28:53": {Type: "dns", Address: "www.tsinghua.edu.cn", Server: "166.111.8.28:53"},
+		}
+		for spec, want := range cases {
+			got, err := parseProbeTarget(spec)
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, want)
+		}
+	})
+
+	Convey("parseProbeTarget should reject unknown schemes", t, func() {
+		for _, spec := range []string{"ftp://example.com/", "example.com", "icmp://166.111.4.100"} {
+			_, err := parseProbeTarget(spec)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestNewProbe(t *testing.T) {
+	Convey("newProbe should create the probes of the targets", t, func() {
+		p, err := newProbe(ProbeTarget{Type: "http", Address: "https://www.tsinghua.edu.cn/", ExpectStatus: []int{200, 204}}, false)
+		So(err, ShouldBeNil)
+		So(p.String(), ShouldEqual, "HEAD https://www.tsinghua.edu.cn/")
+
+		p, err = newProbe(ProbeTarget{Type: "tcp", Address: "[2402:f000::1]:443", Family: "v6"}, false)
+		So(err, ShouldBeNil)
+		So(p.String(), ShouldEqual, "tcp6://[2402:f000::1]:443")
+
+		p, err = newProbe(ProbeTarget{Type: "udp", Address: "166.111.8.28:53"}, false)
+		So(err, ShouldBeNil)
+		So(p.String(), ShouldEqual, "udp4://166.111.8.28:53")
+
+		p, err = newProbe(ProbeTarget{Type: "dns", Address: "www.tsinghua.edu.cn", Server: "166.111.8.28:53"}, false)
+		So(err, ShouldBeNil)
+		So(p.String(), ShouldEqual, "dns://www.tsinghua.edu.cn")
+	})
+
+	Convey("newProbe should use the family of useV6 by default", t, func() {
+		p, err := newProbe(ProbeTarget{Type: "tcp", Address: "www.tsinghua.edu.cn:443"}, true)
+		So(err, ShouldBeNil)
+		So(p.String(), ShouldEqual, "tcp6://www.tsinghua.edu.cn:443")
+	})
+
+	Convey("newProbe should reject invalid targets", t, func() {
+		invalid := []ProbeTarget{
+			{Type: "icmp", Address: "166.111.4.100"},
+			{Type: "http", Address: "https://www.tsinghua.edu.cn/", Method: "POST"},
+			{Type: "http", Address: "https://www.tsinghua.edu.cn/", Family: "v5"},
+			{Type: "http", Address: "https://www.tsinghua.edu.cn/", ExpectStatus: []int{200, 2000}},
+			{Type: "tcp", Address: "166.111.4.100:443", ExpectStatus: []int{200}},
+		}
+		for _, target := range invalid {
+			_, err := newProbe(target, false)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestAllCriticalFailed(t *testing.T) {
+	Convey("keepAlive should fail once all targets failed onlineRetry times", t, func() {
+		probes := []*keepAliveProbe{
+			{family: "v4", critical: true, errorCount: 2},
+			{family: "v4", critical: true, errorCount: 1},
+		}
+		So(allCriticalFailed(probes, 2), ShouldBeFalse)
+		probes[1].errorCount = 2
+		So(allCriticalFailed(probes, 2), ShouldBeTrue)
+		probes[0].errorCount = 0
+		So(allCriticalFailed(probes, 2), ShouldBeFalse)
+	})
+}
+
+func TestBackgroundV6(t *testing.T) {
+	Convey("The background IPv6 leg should not be critical", t, func() {
+		s := &Settings{OnIntrvl: 3, OnRetry: 2, V6Intrvl: 60}
+		probes, err := keepAliveProbes(s, false)
+		So(err, ShouldBeNil)
+		So(len(probes), ShouldEqual, 2)
+		So(probes[0].family, ShouldEqual, "v4")
+		So(probes[0].critical, ShouldBeTrue)
+		So(probes[1].family, ShouldEqual, "v6")
+		So(probes[1].critical, ShouldBeFalse)
+
+		s.V6 = true
+		probes, err = keepAliveProbes(s, false)
+		So(err, ShouldBeNil)
+		So(len(probes), ShouldEqual, 1)
+	})
+
+	Convey("A failing IPv6 leg should be reported without failing keepAlive", t, func() {
+		probes := []*keepAliveProbe{
+			{family: "v4", critical: true},
+			{family: "v6", errorCount: 5},
+		}
+		So(familyDown(probes, "v6", 2), ShouldBeTrue)
+		So(familyDown(probes, "v4", 2), ShouldBeFalse)
+		So(allCriticalFailed(probes, 2), ShouldBeFalse)
+
+		probes[0].errorCount = 2
+		So(familyDown(probes, "v4", 2), ShouldBeTrue)
+		So(allCriticalFailed(probes, 2), ShouldBeTrue)
+
+		So(familyDown(probes[:1], "v6", 2), ShouldBeFalse)
+	})
+}
+
+func TestV6IntervalSetting(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	writeConfig := func(interval int) string {
+		cf := filepath.Join(home, fmt.Sprintf("v6-%d.json", interval))
+		config := fmt.Sprintf(`{"keepAliveV6Interval": %d}`, interval)
+		if err := os.WriteFile(cf, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return cf
+	}
+
+	Convey("keepAliveV6Interval 0 should mean the default, and negative disable", t, func() {
+		cases := []struct {
+			args     []string
+			interval int
+		}{
+			{[]string{"online"}, defaultV6Interval},
+			{[]string{"-c", writeConfig(0), "online"}, defaultV6Interval},
+			{[]string{"-c", writeConfig(60), "online"}, 60},
+			{[]string{"-c", writeConfig(-1), "online"}, -1},
+			{[]string{"--keepalive-v6-interval=0", "online"}, defaultV6Interval},
+			{[]string{"--keepalive-v6-interval=-1", "online"}, -1},
+			{[]string{"-c", writeConfig(-1), "--keepalive-v6-interval=60", "online"}, 60},
+		}
+		for _, c := range cases {
+			_, err := runParseSettings(c.args...)
+			So(err, ShouldBeNil)
+			So(settings.V6Intrvl, ShouldEqual, c.interval)
+
+			probes, err := keepAliveProbes(&settings, false)
+			So(err, ShouldBeNil)
+			So(len(probes) == 2, ShouldEqual, c.interval > 0)
+		}
+	})
+}
@@ -4,14 +4,15 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"net"
-	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/howeyc/gopass"
@@ -19,6 +20,7 @@ import (
 	"github.com/urfave/cli/v3"
 
 	"github.com/z4yx/GoAuthing/libauth"
+	"github.com/z4yx/GoAuthing/notify"
 )
 
 type Settings struct {
@@ -31,102 +33,333 @@ type Settings struct {
 	KeepOn   bool   `json:"keepOnline"`
 	OnIntrvl int    `json:"onlineInterval"`
 	OnRetry  int    `json:"onlineRetry"`
-	V6       bool   `json:"useV6"`
-	Insecure bool   `json:"insecure"`
-	Daemon   bool   `json:"daemonize"`
-	Debug    bool   `json:"debug"`
-	AcID     string `json:"acId"`
-	Campus   bool   `json:"campusOnly"`
-	Timeout  int    `json:"timeout"`
+	// CheckIntrvl is the interval of online checking in daemon mode
+	CheckIntrvl int    `json:"checkInterval"`
+	V6          bool   `json:"useV6"`
+	Insecure    bool   `json:"insecure"`
+	Daemon      bool   `json:"daemonize"`
+	Debug       bool   `json:"debug"`
+	AcID        string `json:"acId"`
+	Campus      bool   `json:"campusOnly"`
+	Timeout     int    `json:"timeout"`
+	Lang        string `json:"lang"`
+	// DualStack authenticates on both auth4 and auth6
+	DualStack bool `json:"dualStack"`
+	// Retrying of transient login failures
+	LoginRetries    int     `json:"loginRetries"`
+	RetryBackoff    int     `json:"retryBackoff"`
+	RetryMaxBackoff int     `json:"retryMaxBackoff"`
+	RetryJitter     float64 `json:"retryJitter"`
+	// KeepAliveTargets replaces the default keepalive target
+	KeepAliveTargets []ProbeTarget `json:"keepAliveTargets"`
+	// The background IPv6 keepalive, every defaultV6Interval if
+	// V6Intrvl is 0 and disabled if V6Intrvl < 0
+	V6Target string `json:"keepAliveV6Target"`
+	V6Intrvl int    `json:"keepAliveV6Interval"`
+	// Profiles are named sets of settings, applied on top of the others
+	// when selected with --profile
+	Profiles map[string]json.RawMessage `json:"profiles,omitempty"`
+	// Sources of the password other than Password and the prompt
+	PasswordFile  string `json:"passwordFile"`
+	PasswordStdin bool   `json:"passwordStdin"`
+	PasswordCmd   string `json:"passwordCommand"`
+	// Keyring is "auto" (default), "secret-service", "file" or "none"
+	Keyring     string `json:"keyring"`
+	KeyringFile string `json:"keyringFile"`
+	// Hooks run on each event, see hooks.go
+	HookLogin   string `json:"hook-login"`
+	HookLogout  string `json:"hook-logout"`
+	HookFailure string `json:"hook-failure"`
+	HookOffline string `json:"hook-offline-detected"`
+	HookKaLost  string `json:"hook-keepalive-lost"`
+	HookTimeout int    `json:"hookTimeout"`
+	HookShell   bool   `json:"hookShell"`
+	// Webhooks are notified of the same events as the hooks
+	Webhooks []notify.Webhook `json:"webhooks"`
+	// MetricsListen is the address of the Prometheus endpoint, e.g. ":9417"
+	MetricsListen string `json:"metricsListen"`
+	// The control API listens on a Unix socket and/or a loopback address
+	ControlSocket string `json:"controlSocket"`
+	ControlListen string `json:"controlListen"`
 }
 
 var logger = loggo.GetLogger("auth-thu")
 var settings Settings
 
-func parseSettingsFile(path string) error {
+// current holds the settings of the long-running goroutines once
+// published, so that reloadSettings can replace them as a whole
+var current atomic.Pointer[Settings]
+
+// snapshot returns the published settings, or the ones being parsed
+// before that. They must not be modified; loops take one snapshot per
+// iteration, so that a reload applies from the next one.
+func snapshot() *Settings {
+	if s := current.Load(); s != nil {
+		return s
+	}
+	return &settings
+}
+
+// publishSettings publishes the settings, once the credentials are
+// complete, before starting the goroutines which read them. The password
+// of passwordFile, passwordCommand or the keyring is resolved here once, as
+// reloadSettings does, so that a reload compares it with the new one.
+func publishSettings() {
+	if current.Load() != nil {
+		return
+	}
+	s := settings
+	if len(s.Username) != 0 && len(s.Password) == 0 && !s.PasswordStdin {
+		password, err := passwordFromSources(&s)
+		if err != nil {
+			// credentials tries again when logging in
+			logger.Debugf("Read password failed: %s\n", err)
+		} else if len(password) == 0 {
+			password = keyringPassword(&s)
+		}
+		s.Password = password
+	}
+	current.CompareAndSwap(nil, &s)
+}
+
+func parseSettingsFile(path string, s *Settings) error {
 	sf, err := os.Open(path)
 	if err != nil {
 		return fmt.Errorf("read config file failed (%s)", err)
 	}
 	defer sf.Close()
 	bv, _ := ioutil.ReadAll(sf)
-	err = json.Unmarshal(bv, &settings)
+	err = decodeConfig(path, bv, s)
 	if err != nil {
-		return fmt.Errorf("parse config file \"%s\" failed (%s)", path, err)
+		return fmt.Errorf("parse config file failed: %w", err)
 	}
 	logger.Debugf("Read config file \"%s\" succeeded\n", path)
 	return nil
 }
 
-func mergeCliSettings(c *cli.Command) {
+func mergeCliSettings(c *cli.Command, s *Settings) error {
 	var merged Settings
 	merged.Username = c.String("username")
 	if len(merged.Username) == 0 {
-		merged.Username = settings.Username
+		merged.Username = s.Username
 	}
 	merged.Password = c.String("password")
-	if len(merged.Password) == 0 {
-		merged.Password = settings.Password
+	if len(merged.Password) == 0 && !c.IsSet("password-file") && !c.Bool("password-stdin") {
+		merged.Password = s.Password
+	}
+	merged.PasswordFile = c.String("password-file")
+	if len(merged.PasswordFile) == 0 {
+		merged.PasswordFile = s.PasswordFile
+	}
+	merged.PasswordStdin = s.PasswordStdin || c.Bool("password-stdin")
+	merged.PasswordCmd = s.PasswordCmd
+	merged.Keyring = c.String("keyring")
+	if len(merged.Keyring) == 0 {
+		merged.Keyring = s.Keyring
 	}
+	merged.KeyringFile = s.KeyringFile
 	merged.Ip = c.String("ip")
 	if len(merged.Ip) == 0 {
-		merged.Ip = settings.Ip
+		merged.Ip = s.Ip
 	}
 	merged.Host = c.String("host")
 	if len(merged.Host) == 0 {
-		merged.Host = settings.Host
+		merged.Host = s.Host
 	}
 	merged.HookSucc = c.String("hook-success")
 	if len(merged.HookSucc) == 0 {
-		merged.HookSucc = settings.HookSucc
+		merged.HookSucc = s.HookSucc
+	}
+	merged.HookLogin = c.String("hook-login")
+	if len(merged.HookLogin) == 0 {
+		merged.HookLogin = s.HookLogin
+	}
+	merged.HookLogout = c.String("hook-logout")
+	if len(merged.HookLogout) == 0 {
+		merged.HookLogout = s.HookLogout
+	}
+	merged.HookFailure = c.String("hook-failure")
+	if len(merged.HookFailure) == 0 {
+		merged.HookFailure = s.HookFailure
+	}
+	merged.HookOffline = c.String("hook-offline-detected")
+	if len(merged.HookOffline) == 0 {
+		merged.HookOffline = s.HookOffline
+	}
+	merged.HookKaLost = c.String("hook-keepalive-lost")
+	if len(merged.HookKaLost) == 0 {
+		merged.HookKaLost = s.HookKaLost
+	}
+	merged.HookTimeout = c.Int("hook-timeout")
+	if !c.IsSet("hook-timeout") && s.HookTimeout != 0 {
+		merged.HookTimeout = s.HookTimeout
+	}
+	if merged.HookTimeout == 0 {
+		// A hung hook would hold up every later event
+		merged.HookTimeout = defaultHookTimeout
+	}
+	merged.HookShell = s.HookShell || c.Bool("hook-shell")
+	merged.Webhooks = s.Webhooks
+	if c.IsSet("webhook") {
+		merged.Webhooks = nil
+		for _, url := range c.StringSlice("webhook") {
+			merged.Webhooks = append(merged.Webhooks, notify.Webhook{URL: url})
+		}
+	}
+	merged.MetricsListen = c.String("metrics-listen")
+	if len(merged.MetricsListen) == 0 {
+		merged.MetricsListen = s.MetricsListen
+	}
+	merged.ControlSocket = c.String("control-socket")
+	if len(merged.ControlSocket) == 0 {
+		merged.ControlSocket = s.ControlSocket
+	}
+	merged.ControlListen = c.String("control-listen")
+	if len(merged.ControlListen) == 0 {
+		merged.ControlListen = s.ControlListen
 	}
-	merged.NoCheck = settings.NoCheck || c.Bool("no-check")
-	merged.V6 = settings.V6 || c.Bool("ipv6")
-	merged.KeepOn = settings.KeepOn || c.Bool("keep-online")
+	merged.NoCheck = s.NoCheck || c.Bool("no-check")
+	merged.V6 = s.V6 || c.Bool("ipv6")
+	merged.DualStack = s.DualStack || c.Bool("dual-stack")
+	merged.KeepOn = s.KeepOn || c.Bool("keep-online")
 	merged.OnIntrvl = c.Int("online-interval")
-	if !c.IsSet("online-interval") && settings.OnIntrvl != 0 {
+	if !c.IsSet("online-interval") && s.OnIntrvl != 0 {
 		// if no cmd arg but has settings item, settings precedes.
-		merged.OnIntrvl = settings.OnIntrvl
+		merged.OnIntrvl = s.OnIntrvl
 	}
 	merged.OnRetry = c.Int("r") // online-retry
-	if !c.IsSet("r") && settings.OnRetry != 0 {
-		merged.OnRetry = settings.OnRetry
+	if !c.IsSet("r") && s.OnRetry != 0 {
+		merged.OnRetry = s.OnRetry
 	}
-	merged.Insecure = settings.Insecure || c.Bool("insecure")
-	merged.Daemon = settings.Daemon || c.Bool("daemonize")
-	merged.Debug = settings.Debug || c.Bool("debug")
+	merged.CheckIntrvl = c.Int("check-interval")
+	if !c.IsSet("check-interval") && s.CheckIntrvl != 0 {
+		merged.CheckIntrvl = s.CheckIntrvl
+	}
+	merged.Insecure = s.Insecure || c.Bool("insecure")
+	merged.Daemon = s.Daemon || c.Bool("daemonize")
+	merged.Debug = s.Debug || c.Bool("debug")
 	merged.AcID = c.String("ac-id")
 	if len(merged.AcID) == 0 {
-		merged.AcID = settings.AcID
+		merged.AcID = s.AcID
 	}
-	merged.Campus = settings.Campus || c.Bool("campus-only")
+	merged.Campus = s.Campus || c.Bool("campus-only")
 	merged.Timeout = c.Int("timeout")
-	if !c.IsSet("timeout") && settings.Timeout != 0 {
-		merged.Timeout = settings.Timeout
+	if !c.IsSet("timeout") && s.Timeout != 0 {
+		merged.Timeout = s.Timeout
+	}
+	merged.LoginRetries = c.Int("login-retries")
+	if !c.IsSet("login-retries") && s.LoginRetries != 0 {
+		merged.LoginRetries = s.LoginRetries
+	}
+	merged.RetryBackoff = c.Int("retry-backoff")
+	if !c.IsSet("retry-backoff") && s.RetryBackoff != 0 {
+		merged.RetryBackoff = s.RetryBackoff
+	}
+	merged.RetryMaxBackoff = c.Int("retry-max-backoff")
+	if !c.IsSet("retry-max-backoff") && s.RetryMaxBackoff != 0 {
+		merged.RetryMaxBackoff = s.RetryMaxBackoff
+	}
+	merged.RetryJitter = c.Float("retry-jitter")
+	if !c.IsSet("retry-jitter") && s.RetryJitter != 0 {
+		merged.RetryJitter = s.RetryJitter
+	}
+	merged.KeepAliveTargets = s.KeepAliveTargets
+	if c.IsSet("keepalive-target") {
+		merged.KeepAliveTargets = nil
+		for _, spec := range c.StringSlice("keepalive-target") {
+			t, err := parseProbeTarget(spec)
+			if err != nil {
+				return err
+			}
+			merged.KeepAliveTargets = append(merged.KeepAliveTargets, t)
+		}
 	}
-	settings = merged
-	if settings.Timeout > 0 {
-		libauth.HttpTimeout = time.Duration(settings.Timeout) * time.Second
+	merged.V6Target = c.String("keepalive-v6-target")
+	if len(merged.V6Target) == 0 {
+		merged.V6Target = s.V6Target
+	}
+	merged.V6Intrvl = c.Int("keepalive-v6-interval")
+	if !c.IsSet("keepalive-v6-interval") && s.V6Intrvl != 0 {
+		merged.V6Intrvl = s.V6Intrvl
+	}
+	if merged.V6Intrvl == 0 {
+		// 0 means the default, as unset in the config file
+		merged.V6Intrvl = defaultV6Interval
+	}
+	merged.Lang = c.String("lang")
+	if len(merged.Lang) == 0 {
+		merged.Lang = s.Lang
+	}
+	if len(merged.Lang) == 0 {
+		merged.Lang = envLang()
+	}
+	*s = merged
+	logger.Debugf("Settings Username: \"%s\"\n", s.Username)
+	logger.Debugf("Settings Ip: \"%s\"\n", s.Ip)
+	logger.Debugf("Settings Host: \"%s\"\n", s.Host)
+	logger.Debugf("Settings HookSucc: \"%s\"\n", s.HookSucc)
+	logger.Debugf("Settings HookLogin: \"%s\"\n", s.HookLogin)
+	logger.Debugf("Settings HookLogout: \"%s\"\n", s.HookLogout)
+	logger.Debugf("Settings HookFailure: \"%s\"\n", s.HookFailure)
+	logger.Debugf("Settings HookOffline: \"%s\"\n", s.HookOffline)
+	logger.Debugf("Settings HookKaLost: \"%s\"\n", s.HookKaLost)
+	logger.Debugf("Settings HookTimeout: %d\n", s.HookTimeout)
+	logger.Debugf("Settings HookShell: %t\n", s.HookShell)
+	logger.Debugf("Settings Webhooks: %d\n", len(s.Webhooks))
+	logger.Debugf("Settings MetricsListen: \"%s\"\n", s.MetricsListen)
+	logger.Debugf("Settings ControlSocket: \"%s\"\n", s.ControlSocket)
+	logger.Debugf("Settings ControlListen: \"%s\"\n", s.ControlListen)
+	logger.Debugf("Settings NoCheck: %t\n", s.NoCheck)
+	logger.Debugf("Settings V6: %t\n", s.V6)
+	logger.Debugf("Settings DualStack: %t\n", s.DualStack)
+	logger.Debugf("Settings KeepOn: %t\n", s.KeepOn)
+	logger.Debugf("Settings OnIntrvl: %v\n", s.OnIntrvl)
+	logger.Debugf("Settings OnRetry: %v\n", s.OnRetry)
+	logger.Debugf("Settings CheckIntrvl: %v\n", s.CheckIntrvl)
+	logger.Debugf("Settings Insecure: %t\n", s.Insecure)
+	logger.Debugf("Settings Daemon: %t\n", s.Daemon)
+	logger.Debugf("Settings Debug: %t\n", s.Debug)
+	logger.Debugf("Settings AcID: \"%s\"\n", s.AcID)
+	logger.Debugf("Settings Campus: %t\n", s.Campus)
+	logger.Debugf("Settings Timeout: %d\n", s.Timeout)
+	logger.Debugf("Settings KeepAliveTargets: %+v\n", s.KeepAliveTargets)
+	logger.Debugf("Settings V6Target: \"%s\"\n", s.V6Target)
+	logger.Debugf("Settings V6Intrvl: %d\n", s.V6Intrvl)
+	logger.Debugf("Settings Lang: \"%s\"\n", s.Lang)
+	logger.Debugf("Settings PasswordFile: \"%s\"\n", s.PasswordFile)
+	logger.Debugf("Settings PasswordStdin: %t\n", s.PasswordStdin)
+	logger.Debugf("Settings PasswordCmd: \"%s\"\n", s.PasswordCmd)
+	logger.Debugf("Settings Keyring: \"%s\"\n", s.Keyring)
+	logger.Debugf("Settings KeyringFile: \"%s\"\n", s.KeyringFile)
+	logger.Debugf("Settings LoginRetries: %d\n", s.LoginRetries)
+	logger.Debugf("Settings RetryBackoff: %d\n", s.RetryBackoff)
+	logger.Debugf("Settings RetryMaxBackoff: %d\n", s.RetryMaxBackoff)
+	logger.Debugf("Settings RetryJitter: %v\n", s.RetryJitter)
+	return nil
+}
+
+// envLang returns the language of messages from the locale environment
+func envLang() string {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if lang := os.Getenv(env); len(lang) != 0 && lang != "C" && lang != "POSIX" {
+			return lang
+		}
 	}
-	logger.Debugf("Settings Username: \"%s\"\n", settings.Username)
-	logger.Debugf("Settings Ip: \"%s\"\n", settings.Ip)
-	logger.Debugf("Settings Host: \"%s\"\n", settings.Host)
-	logger.Debugf("Settings HookSucc: \"%s\"\n", settings.HookSucc)
-	logger.Debugf("Settings NoCheck: %t\n", settings.NoCheck)
-	logger.Debugf("Settings V6: %t\n", settings.V6)
-	logger.Debugf("Settings KeepOn: %t\n", settings.KeepOn)
-	logger.Debugf("Settings OnIntrvl: %v\n", settings.OnIntrvl)
-	logger.Debugf("Settings OnRetry: %v\n", settings.OnRetry)
-	logger.Debugf("Settings Insecure: %t\n", settings.Insecure)
-	logger.Debugf("Settings Daemon: %t\n", settings.Daemon)
-	logger.Debugf("Settings Debug: %t\n", settings.Debug)
-	logger.Debugf("Settings AcID: \"%s\"\n", settings.AcID)
-	logger.Debugf("Settings Campus: %t\n", settings.Campus)
-	logger.Debugf("Settings Timeout: %d\n", settings.Timeout)
+	return libauth.DefaultLanguage
+}
+
+// localize translates err to settings.Lang if it is a PortalError
+func localize(err error) error {
+	var pe *libauth.PortalError
+	if errors.As(err, &pe) {
+		return pe.Localize(snapshot().Lang)
+	}
+	return err
 }
 
 func requestUser() (err error) {
-	if len(settings.Username) == 0 && !settings.Daemon {
+	// Standard input is reserved for the password with --password-stdin
+	if len(settings.Username) == 0 && !settings.Daemon && !settings.PasswordStdin {
 		reader := bufio.NewReader(os.Stdin)
 		fmt.Print("Username: ")
 		settings.Username, _ = reader.ReadString('\n')
@@ -139,7 +372,16 @@ func requestUser() (err error) {
 }
 
 func requestPasswd() (err error) {
-	if len(settings.Password) == 0 && !settings.Daemon {
+	if len(settings.Password) == 0 {
+		settings.Password, err = passwordFromSources(&settings)
+		if err != nil {
+			return
+		}
+	}
+	if len(settings.Password) == 0 {
+		settings.Password = keyringPassword(&settings)
+	}
+	if len(settings.Password) == 0 && !settings.Daemon && !settings.PasswordStdin {
 		var b []byte
 		fmt.Printf("Password: ")
 		b, err = gopass.GetPasswdMasked()
@@ -192,6 +434,37 @@ func locateConfigFile(c *cli.Command) (cf string) {
 	return ""
 }
 
+// loadSettings parses the config file, the environment and the flags into
+// new settings, without touching the current ones
+func loadSettings(c *cli.Command) (s Settings, err error) {
+	cf := locateConfigFile(c)
+	if len(cf) != 0 {
+		err = parseSettingsFile(cf, &s)
+		if err != nil {
+			return s, err
+		}
+	}
+	if profile := c.String("profile"); len(profile) != 0 {
+		err = selectProfile(profile, &s)
+		if err != nil {
+			return s, err
+		}
+	}
+	envApplied, err := applyEnvSettings(&s)
+	if err != nil {
+		return s, err
+	}
+	if len(cf) == 0 && envApplied == 0 && c.Bool("daemonize") {
+		return s, fmt.Errorf("cannot find config file (it is necessary in daemon mode)")
+	}
+	err = mergeCliSettings(c, &s)
+	if err != nil {
+		return s, err
+	}
+	err = validateSettings(&s)
+	return s, err
+}
+
 func parseSettings(c *cli.Command) (err error) {
 	if c.Bool("help") {
 		cli.ShowAppHelpAndExit(c, 0)
@@ -199,141 +472,88 @@ func parseSettings(c *cli.Command) (err error) {
 	// Early debug flag setting (have debug messages when access config file)
 	setLoggerLevel(c.Bool("debug"), c.Bool("daemonize"))
 
-	cf := locateConfigFile(c)
-	if len(cf) == 0 && c.Bool("daemonize") {
-		return fmt.Errorf("cannot find config file (it is necessary in daemon mode)")
+	s, err := loadSettings(c)
+	if err != nil {
+		return err
 	}
-	if len(cf) != 0 {
-		err = parseSettingsFile(cf)
-		if err != nil {
-			return err
-		}
+	settings = s
+	if settings.Timeout > 0 {
+		libauth.HttpTimeout = time.Duration(settings.Timeout) * time.Second
 	}
-	mergeCliSettings(c)
 	// Late debug flag setting
 	setLoggerLevel(settings.Debug, settings.Daemon)
 	return
 }
 
-func runHook(c *cli.Command) {
-	if settings.HookSucc != "" {
-		logger.Debugf("Run hook \"%s\"\n", settings.HookSucc)
-		cmd := exec.Command(settings.HookSucc)
-		if err := cmd.Run(); err != nil {
-			logger.Errorf("Hook execution failed: %v\n", err)
-		}
-	}
+// portalDomain returns the configured auth server, or auth4/6.tsinghua
+func portalDomain() string {
+	return domainOf(snapshot())
 }
 
-func keepAliveLoop(c *cli.Command, campusOnly bool) (ret error) {
-	logger.Infof("Accessing websites periodically to keep you online")
+// authDomains are the auth servers of IPv4 and IPv6
+var authDomains = [2]string{"auth4.tsinghua.edu.cn", "auth6.tsinghua.edu.cn"}
 
-	accessTarget := func(url string, ipv6 bool) (ret error) {
-		network := "tcp4"
-		if ipv6 {
-			network = "tcp6"
-		}
-		netClient := &http.Client{
-			Timeout: time.Second * 10,
-			Transport: &http.Transport{
-				DialContext: func(ctx context.Context, _network, addr string) (net.Conn, error) {
-					logger.Debugf("DialContext %s (%s)\n", addr, network)
-					myDial := &net.Dialer{
-						Timeout:       6 * time.Second,
-						KeepAlive:     0,
-						FallbackDelay: -1, // disable RFC 6555 Fast Fallback
-					}
-					return myDial.DialContext(ctx, network, addr)
-				},
-			},
-		}
-		resp, ret := netClient.Head(url)
-		if ret != nil {
-			return
-		}
-		defer resp.Body.Close()
-		logger.Debugf("HTTP status code %d\n", resp.StatusCode)
-		return
+func domainOf(s *Settings) string {
+	if len(s.Host) != 0 {
+		return s.Host
 	}
-	targetInside := "https://www.tsinghua.edu.cn/"
-	targetOutside := "https://www.baidu.com/"
-
-	stop := make(chan int, 1)
-	defer func() { stop <- 1 }()
-	go func() {
-		// Keep IPv6 online, ignore any errors
-		for {
-			select {
-			case <-stop:
-				break
-			case <-time.After(13 * time.Minute):
-				_ = accessTarget(targetInside, true)
-			}
-		}
-	}()
-
-	errorCount := 0
-	for {
-		target := targetOutside
-		if campusOnly || settings.V6 {
-			target = targetInside
-		}
-		if ret = accessTarget(target, settings.V6); ret != nil {
-			errorCount++
-			if errorCount >= settings.OnRetry {
-				ret = fmt.Errorf("keepAlive request error (re-login might be required): %w\n", ret)
-				break
-			} else {
-				logger.Infof("keepAlive request error (will retry): %s\n", ret)
-			}
-		} else {
-			errorCount = 0
-		}
-		// Consumes ~5MB per day when settings.OnIntrvl == 3
-		time.Sleep(time.Duration(settings.OnIntrvl) * time.Second)
+	if s.V6 {
+		return authDomains[1]
 	}
-	return
+	return authDomains[0]
 }
 
-func authUtil(c *cli.Command, logout bool) error {
-	err := parseSettings(c)
-	if err != nil {
-		return err
-	}
-	acID := "1"
-	if len(settings.AcID) != 0 {
-		acID = settings.AcID
-	}
-	domain := settings.Host
-	if len(settings.Host) == 0 {
-		if settings.V6 {
-			domain = "auth6.tsinghua.edu.cn"
-		} else {
-			domain = "auth4.tsinghua.edu.cn"
-		}
-	}
+// portalParams returns the auth server and the ac_id to use, probing ac_id
+// if it is not configured
+func portalParams(ctx context.Context) (host *libauth.UrlProvider, acID string) {
+	return portalParamsOf(ctx, snapshot())
+}
 
-	if len(settings.Ip) == 0 && len(settings.AcID) == 0 {
+// portalParamsOf is portalParams of other settings, e.g. the ones before
+// reloading
+func portalParamsOf(ctx context.Context, s *Settings) (host *libauth.UrlProvider, acID string) {
+	acID = "1"
+	if len(s.AcID) != 0 {
+		acID = s.AcID
+	}
+	if len(s.Ip) == 0 && len(s.AcID) == 0 {
 		// Probe the ac_id parameter
 		// We do this only in Tsinghua, since it requires access to usereg.t.e.c/net.t.e.c
-		retAcID, err := libauth.GetAcID(settings.V6)
+		retAcID, err := libauth.GetAcIDContext(ctx, s.V6)
 		if err != nil || retAcID == "1" {
 			logger.Debugf("Failed to get ac_id: %v", err)
 			logger.Debugf("Login may fail with '找不到符合条件的控制策略'.")
 		}
 		acID = retAcID
 	}
+	host = libauth.NewUrlProvider(domainOf(s), s.Insecure)
+	return
+}
 
-	host := libauth.NewUrlProvider(domain, settings.Insecure)
+func authUtil(ctx context.Context, c *cli.Command, logout bool) error {
+	err := parseSettings(c)
+	if err != nil {
+		return err
+	}
+	if settings.KeepOn && !logout && len(settings.Ip) != 0 {
+		return fmt.Errorf("keepOnline cannot be used with ip (cannot keep another IP online)")
+	}
+	if len(c.String("ip-file")) != 0 {
+		return batchAuth(ctx, c, logout)
+	}
+	if settings.DualStack {
+		return dualStackAuth(ctx, c, logout)
+	}
+	host, acID := portalParams(ctx)
 	if len(settings.Ip) == 0 && !settings.NoCheck {
-		online, _, username := libauth.IsOnline(host, acID)
+		online, _, username := libauth.IsOnlineContext(ctx, host, acID)
 		if logout && online {
 			settings.Username = username
 		}
 		if online && !logout {
 			logger.Infof("Currently online!")
 			if settings.KeepOn {
-				return keepAliveLoop(c, settings.Campus)
+				return keepAliveLoop(ctx, c, settings.Campus)
 			}
 			return nil
 		} else if !online && logout {
@@ -363,42 +583,41 @@ func authUtil(c *cli.Command, logout bool) error {
 		settings.Username += "@tsinghua"
 	}
 
-	err = libauth.LoginLogout(settings.Username, settings.Password, host, logout, settings.Ip, acID)
 	action := "Login"
 	if logout {
 		action = "Logout"
 	}
+	err = withRetry(ctx, action, func() error {
+		return libauth.LoginLogoutContext(ctx, settings.Username, settings.Password, host, logout, settings.Ip, acID)
+	})
 	if err == nil {
 		logger.Infof("%s Successfully!\n", action)
-		runHook(c)
-		if settings.KeepOn {
-			if len(settings.Ip) != 0 {
-				logger.Errorf("Cannot keep another IP online\n")
-			} else {
-				return keepAliveLoop(c, settings.Campus)
-			}
+		emitEvent(newHookEvent(authAction(logout), settings.Username, settings.Ip, nil))
+		if settings.KeepOn && !logout {
+			return keepAliveLoop(ctx, c, settings.Campus)
 		}
 	} else {
-		err = fmt.Errorf("%s Failed: %w", action, err)
+		emitEvent(newHookEvent(actionFailure, settings.Username, settings.Ip, err))
+		err = fmt.Errorf("%s Failed: %w", action, localize(err))
 	}
 	return err
 }
 
 func cmdAuth(ctx context.Context, c *cli.Command) error {
 	logout := c.Bool("logout")
-	err := authUtil(c, logout)
+	err := authUtil(ctx, c, logout)
 	if err != nil {
 		logger.Errorf("Auth error: %s", err)
-		os.Exit(1)
+		exit(1)
 	}
 	return nil
 }
 
 func cmdDeauth(ctx context.Context, c *cli.Command) error {
-	err := authUtil(c, true)
+	err := authUtil(ctx, c, true)
 	if err != nil {
 		logger.Errorf("Deauth error: %s\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 	return nil
 }
@@ -407,34 +626,63 @@ func cmdKeepalive(ctx context.Context, c *cli.Command) error {
 	err := parseSettings(c)
 	if err != nil {
 		logger.Errorf("Parse setting error: %s\n", err)
-		os.Exit(1)
+		exit(1)
 	}
-	err = keepAliveLoop(c, c.Bool("campus-only"))
+	err = keepAliveLoop(ctx, c, c.Bool("campus-only"))
 	if err != nil {
 		logger.Errorf("Keepalive error: %s\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 	return nil
 }
 
-func main() {
-	cmd := &cli.Command{
+// newApp returns the command line of auth-thu
+func newApp() *cli.Command {
+	return &cli.Command{
 		Name: "auth-thu",
 		UsageText: `auth-thu [options]
 	 auth-thu [options] auth [auth_options]
 	 auth-thu [options] deauth [auth_options]
-	 auth-thu [options] online [online_options]`,
+	 auth-thu [options] online [online_options]
+	 auth-thu [options] status [status_options]
+	 auth-thu [options] daemon [daemon_options]
+	 auth-thu [options] ctl status|login|logout|pause|resume|reload
+	 auth-thu [options] credentials set|get|delete
+	 auth-thu [options] config check`,
 		Usage:    "Authenticating utility for Tsinghua",
 		Version:  "2.4.0",
 		HideHelp: true,
 		Flags: []cli.Flag{
 			&cli.StringFlag{Name: "username", Aliases: []string{"u"}, Usage: "your TUNET account `name`"},
 			&cli.StringFlag{Name: "password", Aliases: []string{"p"}, Usage: "your TUNET `password`"},
-			&cli.StringFlag{Name: "config-file", Aliases: []string{"c"}, Usage: "`path` to your config file, default ~/.auth-thu"},
+			&cli.StringFlag{Name: "password-file", Usage: "read the password from `path` (which must not be group- or world-readable)"},
+			&cli.BoolFlag{Name: "password-stdin", Usage: "read the password from standard input"},
+			&cli.StringFlag{Name: "keyring", Usage: "where credentials are saved: auto, secret-service, file or none (default: auto)"},
+			&cli.StringFlag{Name: "config-file", Aliases: []string{"c"}, Usage: "`path` to your config file, default ~/.auth-thu", Sources: cli.EnvVars(envPrefix + "CONFIG_FILE")},
+			&cli.StringFlag{Name: "profile", Aliases: []string{"P"}, Usage: "use the profile `name` of the config file", Sources: cli.EnvVars(envPrefix + "PROFILE")},
 			&cli.StringFlag{Name: "hook-success", Usage: "command line to be executed in shell after successful login/out"},
+			&cli.StringFlag{Name: "hook-login", Usage: "`command` to be executed after successful login"},
+			&cli.StringFlag{Name: "hook-logout", Usage: "`command` to be executed after successful logout"},
+			&cli.StringFlag{Name: "hook-failure", Usage: "`command` to be executed after failed login/out"},
+			&cli.StringFlag{Name: "hook-offline-detected", Usage: "`command` to be executed when the session is found dropped in daemon mode"},
+			&cli.StringFlag{Name: "hook-keepalive-lost", Usage: "`command` to be executed when keepAlive fails"},
+			&cli.IntFlag{Name: "hook-timeout", Usage: "kill hooks running longer than this (s)", Value: defaultHookTimeout},
+			&cli.BoolFlag{Name: "hook-shell", Usage: "run hook commands in the shell (sh -c), to allow arguments"},
+			&cli.StringFlag{Name: "metrics-listen", Usage: "serve Prometheus metrics on `ADDR` (e.g. :9417) in daemon and online modes"},
+			&cli.StringFlag{Name: "control-socket", Usage: "serve the control API (see ctl) on the Unix socket `path` in daemon and online modes"},
+			&cli.StringFlag{Name: "control-listen", Usage: "serve the control API on the loopback `ADDR` (e.g. 127.0.0.1:9418) in daemon and online modes"},
+			&cli.StringSliceFlag{Name: "webhook", Usage: "`URL` to post the JSON of every login/logout/failure event to (repeatable)"},
 			&cli.IntFlag{Name: "online-interval", Aliases: []string{"I"}, Usage: "the interval between each keepAlive request (s)", Value: 3},
+			&cli.StringSliceFlag{Name: "keepalive-target", Usage: "`URL` to probe for keepAlive: http(s)://..., tcp://host:port, udp://host:port or dns://name[@server:port] (repeatable)"},
+			&cli.StringFlag{Name: "keepalive-v6-target", Usage: "`URL` to probe for the background IPv6 keepAlive, default https://www.tsinghua.edu.cn/"},
+			&cli.IntFlag{Name: "keepalive-v6-interval", Usage: "the interval between each background IPv6 keepAlive request (s), negative to disable", Value: defaultV6Interval},
 			&cli.IntFlag{Name: "timeout", Aliases: []string{"t"}, Usage: "HTTP request timeout in seconds for the auth server", Value: 2},
+			&cli.IntFlag{Name: "login-retries", Usage: "the times to retry login/out on network errors or E2532/E2533", Value: 0},
+			&cli.IntFlag{Name: "retry-backoff", Usage: "the delay before the first retry (s), doubled after each retry", Value: 2},
+			&cli.IntFlag{Name: "retry-max-backoff", Usage: "the maximum delay between retries (s)", Value: 60},
+			&cli.FloatFlag{Name: "retry-jitter", Usage: "randomize retry delays by this fraction", Value: 0.2},
 			&cli.BoolFlag{Name: "daemonize", Aliases: []string{"D"}, Usage: "run without reading username/password from standard input; less log"},
+			&cli.StringFlag{Name: "lang", Usage: "`language` of error messages, e.g. en or zh (default: $LANG)"},
 			&cli.BoolFlag{Name: "debug", Usage: "print debug messages"},
 			&cli.BoolFlag{Name: "help, h", Usage: "print the help"},
 		},
@@ -444,9 +692,12 @@ func main() {
 				Usage: "(default) Auth via auth4/6.tsinghua",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "ip", Usage: "authenticating for specified IP address"},
+					&cli.StringFlag{Name: "ip-file", Usage: "authenticating for each IP address listed in `file`, one \"IP [username]\" per line"},
+					&cli.IntFlag{Name: "jobs", Aliases: []string{"j"}, Usage: "the number of concurrent requests with --ip-file", Value: 4},
 					&cli.BoolFlag{Name: "no-check", Aliases: []string{"n"}, Usage: "skip online checking, always send login request"},
 					&cli.BoolFlag{Name: "logout", Aliases: []string{"o"}, Usage: "de-auth of the online account (behaves the same as deauth command, for backward-compatibility)"},
 					&cli.BoolFlag{Name: "ipv6", Aliases: []string{"6"}, Usage: "authenticating for IPv6 (auth6.tsinghua)"},
+					&cli.BoolFlag{Name: "dual-stack", Usage: "authenticating for both IPv4 and IPv6 (auth4 and auth6.tsinghua)"},
 					&cli.BoolFlag{Name: "campus-only", Aliases: []string{"C"}, Usage: "auth only, no auto-login (v4 only)"},
 					&cli.StringFlag{Name: "host", Usage: "use customized hostname of srun4000"},
 					&cli.BoolFlag{Name: "insecure", Usage: "use http instead of https"},
@@ -461,8 +712,11 @@ func main() {
 				Usage: "De-auth via auth4/6.tsinghua",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "ip", Usage: "authenticating for specified IP address"},
+					&cli.StringFlag{Name: "ip-file", Usage: "de-auth of each IP address listed in `file`, one \"IP [username]\" per line"},
+					&cli.IntFlag{Name: "jobs", Aliases: []string{"j"}, Usage: "the number of concurrent requests with --ip-file", Value: 4},
 					&cli.BoolFlag{Name: "no-check", Aliases: []string{"n"}, Usage: "skip online checking, always send logout request"},
 					&cli.BoolFlag{Name: "ipv6", Aliases: []string{"6"}, Usage: "authenticating for IPv6 (auth6.tsinghua)"},
+					&cli.BoolFlag{Name: "dual-stack", Usage: "de-auth of both IPv4 and IPv6 (auth4 and auth6.tsinghua)"},
 					&cli.StringFlag{Name: "host", Usage: "use customized hostname of srun4000"},
 					&cli.BoolFlag{Name: "insecure", Usage: "use http instead of https"},
 					&cli.StringFlag{Name: "ac-id", Usage: "use specified ac_id"},
@@ -479,6 +733,68 @@ func main() {
 				},
 				Action: cmdKeepalive,
 			},
+			{
+				Name:  "status",
+				Usage: "Show the current session (exits with 3 if offline)",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "print the session details in JSON"},
+					&cli.StringFlag{Name: "ip", Usage: "show the session of specified IP address"},
+					&cli.BoolFlag{Name: "ipv6", Aliases: []string{"6"}, Usage: "query auth6.tsinghua"},
+					&cli.StringFlag{Name: "host", Usage: "use customized hostname of srun4000"},
+					&cli.BoolFlag{Name: "insecure", Usage: "use http instead of https"},
+					&cli.StringFlag{Name: "ac-id", Usage: "use specified ac_id"},
+				},
+				Action: cmdStatus,
+			},
+			{
+				Name:  "daemon",
+				Usage: "Log in when offline and keep online, until interrupted",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "ip", Usage: "authenticating for specified IP address (only re-login, no keepalive)"},
+					&cli.BoolFlag{Name: "ipv6", Aliases: []string{"6"}, Usage: "authenticating for IPv6 (auth6.tsinghua)"},
+					&cli.BoolFlag{Name: "campus-only", Aliases: []string{"C"}, Usage: "auth only, no auto-login (v4 only)"},
+					&cli.StringFlag{Name: "host", Usage: "use customized hostname of srun4000"},
+					&cli.BoolFlag{Name: "insecure", Usage: "use http instead of https"},
+					&cli.StringFlag{Name: "ac-id", Usage: "use specified ac_id"},
+					&cli.IntFlag{Name: "keep-online-retry", Aliases: []string{"r"}, Usage: "the repeat times of failed keepAlive requests before checking the session again", Value: 2},
+					&cli.IntFlag{Name: "check-interval", Usage: "the interval between each online checking (s)", Value: 60},
+					&cli.BoolFlag{Name: "all-profiles", Usage: "run the daemon of every profile in the config file concurrently"},
+				},
+				Action: cmdDaemon,
+			},
+			{
+				Name:  "ctl",
+				Usage: "Control the running daemon/online command through its control API",
+				Commands: []*cli.Command{
+					{
+						Name:   "status",
+						Usage:  "Show the state and the session (exits with 3 if offline)",
+						Flags:  []cli.Flag{&cli.BoolFlag{Name: "json", Usage: "print the state in JSON"}},
+						Action: cmdCtlStatus,
+					},
+					{Name: "login", Usage: "Log in now and resume", Action: ctlAction("/login")},
+					{Name: "logout", Usage: "Log out and pause until login or resume", Action: ctlAction("/logout")},
+					{Name: "pause", Usage: "Pause keepAlive and re-login", Action: ctlAction("/pause")},
+					{Name: "resume", Usage: "Resume keepAlive and re-login", Action: ctlAction("/resume")},
+					{Name: "reload", Usage: "Reload the config file", Action: ctlAction("/reload")},
+				},
+			},
+			{
+				Name:  "credentials",
+				Usage: "Manage the password saved in the keyring",
+				Commands: []*cli.Command{
+					{Name: "set", Usage: "Save the password of the user in the keyring", Action: cmdCredentialsSet},
+					{Name: "get", Usage: "Print the password of the user saved in the keyring", Action: cmdCredentialsGet},
+					{Name: "delete", Usage: "Delete the password of the user from the keyring", Action: cmdCredentialsDelete},
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "Check the config file",
+				Commands: []*cli.Command{
+					{Name: "check", Usage: "Validate the config and print the effective settings (password masked)", Action: cmdConfigCheck},
+				},
+			},
 		},
 		Action: func(ctx context.Context, c *cli.Command) error {
 			if c.NArg() > 0 {
@@ -498,9 +814,17 @@ func main() {
 			"Sharzy L <me@sharzy.in>",
 		},
 	}
+}
+
+func main() {
+	cmd := newApp()
 
-	if err := cmd.Run(context.Background(), os.Args); err != nil {
+	// Abort in-flight requests and the keepalive loop on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := cmd.Run(ctx, os.Args); err != nil {
 		logger.Errorf("Got error: %s", err)
-		os.Exit(1)
+		exit(1)
 	}
+	flushEvents()
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+// metricDef describes a metric in the Prometheus text format
+type metricDef struct {
+	name string
+	kind string
+	help string
+}
+
+var metricDefs = []metricDef{
+	{name: "auth_thu_auth_attempts_total", kind: "counter", help: "Login/logout requests sent to the auth server, retries included."},
+	{name: "auth_thu_auth_failures_total", kind: "counter", help: "Failed login/logout requests by ecode (empty for network errors)."},
+	{name: "auth_thu_keepalive_probes_total", kind: "counter", help: "KeepAlive probes sent."},
+	{name: "auth_thu_keepalive_probe_failures_total", kind: "counter", help: "Failed keepAlive probes."},
+	{name: "auth_thu_keepalive_probe_duration_seconds", kind: "summary", help: "Latency of keepAlive probes."},
+	{name: "auth_thu_online", kind: "gauge", help: "Whether the session is online (1) or not (0)."},
+	{name: "auth_thu_session_bytes_in", kind: "gauge", help: "Bytes received in the current session."},
+	{name: "auth_thu_session_bytes_out", kind: "gauge", help: "Bytes sent in the current session."},
+	{name: "auth_thu_session_duration_seconds", kind: "gauge", help: "Duration of the current session."},
+	{name: "auth_thu_account_bytes", kind: "gauge", help: "Traffic used by the account this month."},
+	{name: "auth_thu_account_balance", kind: "gauge", help: "Balance of the account."},
+	{name: "auth_thu_start_time_seconds", kind: "gauge", help: "Start time of the process since the Unix epoch."},
+	{name: "auth_thu_uptime_seconds", kind: "gauge", help: "Time since the process started."},
+}
+
+// metricRegistry holds the samples of the metrics by name and labels
+type metricRegistry struct {
+	mu      sync.Mutex
+	samples map[string]map[string]float64
+}
+
+var metrics = &metricRegistry{samples: map[string]map[string]float64{}}
+
+var startTime = time.Now()
+
+// metricLabels formats label pairs like {target="...",family="v4"}
+func metricLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (r *metricRegistry) update(name, labels string, f func(float64) float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.samples[name] == nil {
+		r.samples[name] = map[string]float64{}
+	}
+	r.samples[name][labels] = f(r.samples[name][labels])
+}
+
+func (r *metricRegistry) add(name, labels string, v float64) {
+	r.update(name, labels, func(old float64) float64 { return old + v })
+}
+
+func (r *metricRegistry) set(name, labels string, v float64) {
+	r.update(name, labels, func(float64) float64 { return v })
+}
+
+// observeUptime records the start time and uptime of the process
+func observeUptime() {
+	metrics.set("auth_thu_start_time_seconds", "", float64(startTime.Unix()))
+	metrics.set("auth_thu_uptime_seconds", "", time.Since(startTime).Seconds())
+}
+
+// write writes the metrics in the Prometheus text format
+func (r *metricRegistry) write(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, def := range metricDefs {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", def.name, def.help, def.name, def.kind)
+		names := []string{def.name}
+		if def.kind == "summary" {
+			names = []string{def.name + "_sum", def.name + "_count"}
+		}
+		for _, name := range names {
+			labels := make([]string, 0, len(r.samples[name]))
+			for l := range r.samples[name] {
+				labels = append(labels, l)
+			}
+			sort.Strings(labels)
+			for _, l := range labels {
+				fmt.Fprintf(w, "%s%s %v\n", name, l, r.samples[name][l])
+			}
+		}
+	}
+}
+
+// observeAuth records a login/logout request. action is as passed to
+// withRetry, e.g. "IPv6 Logout".
+func observeAuth(action string, err error) {
+	kind := "login"
+	if strings.HasSuffix(action, "Logout") {
+		kind = "logout"
+	}
+	metrics.add("auth_thu_auth_attempts_total", metricLabels("action", kind), 1)
+	if err != nil {
+		ecode := ""
+		var pe *libauth.PortalError
+		if errors.As(err, &pe) {
+			ecode = pe.Code
+		}
+		metrics.add("auth_thu_auth_failures_total", metricLabels("action", kind, "ecode", ecode), 1)
+	}
+}
+
+// observeProbe records a keepAlive probe
+func observeProbe(probe *keepAliveProbe, latency time.Duration, err error) {
+	labels := metricLabels("target", probe.String(), "family", probe.family)
+	metrics.add("auth_thu_keepalive_probes_total", labels, 1)
+	if err != nil {
+		metrics.add("auth_thu_keepalive_probe_failures_total", labels, 1)
+	}
+	metrics.add("auth_thu_keepalive_probe_duration_seconds_sum", labels, latency.Seconds())
+	metrics.add("auth_thu_keepalive_probe_duration_seconds_count", labels, 1)
+}
+
+// observeSession records the online state and the session details
+func observeSession(info *libauth.UserInfo) {
+	if !info.Online {
+		metrics.set("auth_thu_online", "", 0)
+		return
+	}
+	metrics.set("auth_thu_online", "", 1)
+	metrics.set("auth_thu_session_bytes_in", "", float64(info.BytesIn))
+	metrics.set("auth_thu_session_bytes_out", "", float64(info.BytesOut))
+	metrics.set("auth_thu_session_duration_seconds", "", info.OnlineDuration.Seconds())
+	metrics.set("auth_thu_account_bytes", "", float64(info.SumBytes))
+	metrics.set("auth_thu_account_balance", "", info.Balance)
+}
+
+// pollSession queries the session every checkInterval for the metrics
+func pollSession(ctx context.Context) {
+	for {
+		s := snapshot()
+		client := libauth.NewClient(libauth.NewUrlProvider(domainOf(s), s.Insecure), "1")
+		interval := time.Duration(s.CheckIntrvl) * time.Second
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		var info *libauth.UserInfo
+		var err error
+		if len(s.Ip) != 0 {
+			info, err = client.UserInfo(ctx, s.Ip)
+		} else {
+			info, err = client.Status(ctx)
+		}
+		if err == nil {
+			observeSession(info)
+		} else if ctx.Err() == nil {
+			logger.Debugf("Metrics: online check failed: %s\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+var metricsOnce sync.Once
+
+// serveMetrics starts the metrics server on settings.MetricsListen, once,
+// if it is set. It stops when ctx is done. poll starts pollSession for
+// modes which don't check the session themselves.
+func serveMetrics(ctx context.Context, poll bool) {
+	listen := snapshot().MetricsListen
+	if len(listen) == 0 {
+		return
+	}
+	metricsOnce.Do(func() {
+		ln, err := net.Listen("tcp", listen)
+		if err != nil {
+			logger.Errorf("Metrics server failed: %s\n", err)
+			return
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			observeUptime()
+			metrics.write(w)
+		})
+		server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			<-ctx.Done()
+			server.Close()
+		}()
+		go server.Serve(ln)
+		if poll {
+			go pollSession(ctx)
+		}
+		logger.Infof("Serving metrics on http://%s/metrics\n", ln.Addr())
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestMetricsWrite(t *testing.T) {
+	Convey("The metrics should be written in the Prometheus text format", t, func() {
+		saved := metrics
+		metrics = &metricRegistry{samples: map[string]map[string]float64{}}
+		defer func() { metrics = saved }()
+
+		observeAuth("Login", nil)
+		observeAuth("Login", &libauth.PortalError{Code: "E2532"})
+		observeAuth("IPv6 Logout", errors.New("connection refused"))
+		probe := &keepAliveProbe{KeepAliveProbe: &httpProbe{method: "HEAD", url: `https://example.com/"quoted"\path`}, family: "v4"}
+		observeProbe(probe, 250*time.Millisecond, nil)
+		observeProbe(probe, 750*time.Millisecond, errors.New("timeout"))
+		observeProbe(&keepAliveProbe{KeepAliveProbe: &tcpProbe{addr: "[2402:f000::1]:443", network: "tcp6"}, family: "v6"}, time.Second, nil)
+		observeSession(&libauth.UserInfo{Online: true, BytesIn: 2048, BytesOut: 1024, OnlineDuration: time.Hour, SumBytes: 1 << 30, Balance: 12.5})
+
+		var buf bytes.Buffer
+		metrics.write(&buf)
+		golden := filepath.Join("testdata", "metrics.golden")
+		if *update {
+			So(os.WriteFile(golden, buf.Bytes(), 0o644), ShouldBeNil)
+		}
+		want, err := os.ReadFile(golden)
+		So(err, ShouldBeNil)
+		So(buf.String(), ShouldEqual, string(want))
+	})
+
+	Convey("metricLabels should escape the values", t, func() {
+		So(metricLabels(), ShouldEqual, "")
+		So(metricLabels("target", "a\"b\\c\nd", "family", "v4"), ShouldEqual, `{target="a\"b\\c\nd",family="v4"}`)
+	})
+}
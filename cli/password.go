@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// trimNewline removes the trailing newline of a password read from a
+// file or a command, but keeps other whitespace which may be part of it
+func trimNewline(s string) string {
+	return strings.TrimRight(s, "\r\n")
+}
+
+// readPasswordFile reads the password from a file, refusing files which
+// the group or other users can read (e.g. chmod 640 or 644)
+func readPasswordFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("read password file failed (%s)", err)
+	}
+	defer f.Close()
+	if runtime.GOOS != "windows" {
+		fi, err := f.Stat()
+		if err != nil {
+			return "", fmt.Errorf("read password file failed (%s)", err)
+		}
+		if fi.Mode().Perm()&0o044 != 0 {
+			return "", fmt.Errorf("password file \"%s\" is readable by the group or other users, run chmod go-r on it", path)
+		}
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("read password file failed (%s)", err)
+	}
+	logger.Debugf("Read password from \"%s\"\n", path)
+	return trimNewline(string(b)), nil
+}
+
+// readPasswordStdin reads the password from standard input until EOF
+func readPasswordStdin() (string, error) {
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("read password from stdin failed (%s)", err)
+	}
+	return trimNewline(string(b)), nil
+}
+
+// runPasswordCommand runs a helper like "pass show tunet" in the shell,
+// and returns its standard output as the password, which must not be
+// empty
+func runPasswordCommand(command string) (string, error) {
+	logger.Debugf("Run password command \"%s\"\n", command)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("password command failed (%s)", err)
+	}
+	password := trimNewline(stdout.String())
+	if len(password) == 0 {
+		return "", fmt.Errorf("password command printed no password")
+	}
+	return password, nil
+}
+
+// passwordFromSources reads the password from --password-stdin,
+// --password-file or passwordCommand, whichever is set first. It returns
+// an empty password if none is set.
+func passwordFromSources(s *Settings) (string, error) {
+	switch {
+	case s.PasswordStdin:
+		return readPasswordStdin()
+	case len(s.PasswordFile) != 0:
+		return readPasswordFile(s.PasswordFile)
+	case len(s.PasswordCmd) != 0:
+		return runPasswordCommand(s.PasswordCmd)
+	}
+	return "", nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadPasswordFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "password")
+	if err := os.WriteFile(name, []byte(" secret \r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("readPasswordFile should trim only the trailing newline", t, func() {
+		So(os.Chmod(name, 0o600), ShouldBeNil)
+		password, err := readPasswordFile(name)
+		So(err, ShouldBeNil)
+		So(password, ShouldEqual, " secret ")
+
+		So(os.Chmod(name, 0o400), ShouldBeNil)
+		password, err = readPasswordFile(name)
+		So(err, ShouldBeNil)
+		So(password, ShouldEqual, " secret ")
+	})
+
+	Convey("readPasswordFile should refuse group- or world-readable files", t, func() {
+		if runtime.GOOS == "windows" {
+			return
+		}
+		for _, mode := range []os.FileMode{0o640, 0o604, 0o644} {
+			So(os.Chmod(name, mode), ShouldBeNil)
+			_, err := readPasswordFile(name)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "chmod go-r")
+		}
+	})
+
+	Convey("readPasswordFile should fail on a missing file", t, func() {
+		_, err := readPasswordFile(filepath.Join(dir, "missing"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRunPasswordCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands need sh")
+	}
+
+	Convey("runPasswordCommand should trim only the trailing newline", t, func() {
+		password, err := runPasswordCommand(`printf ' secret \n'`)
+		So(err, ShouldBeNil)
+		So(password, ShouldEqual, " secret ")
+	})
+
+	Convey("runPasswordCommand should fail if the command fails", t, func() {
+		_, err := runPasswordCommand("echo secret; exit 3")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "exit status 3")
+	})
+
+	Convey("runPasswordCommand should fail if the command prints nothing", t, func() {
+		_, err := runPasswordCommand("printf '\n'")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "no password")
+	})
+}
+
+func TestReadPasswordStdin(t *testing.T) {
+	// withStdin runs f with standard input reading input
+	withStdin := func(input string, f func()) {
+		name := filepath.Join(t.TempDir(), "stdin")
+		if err := os.WriteFile(name, []byte(input), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		saved := os.Stdin
+		os.Stdin = file
+		defer func() { os.Stdin = saved }()
+		f()
+	}
+
+	Convey("readPasswordStdin should trim only the trailing newline", t, func() {
+		withStdin(" secret \n", func() {
+			password, err := readPasswordStdin()
+			So(err, ShouldBeNil)
+			So(password, ShouldEqual, " secret ")
+		})
+	})
+
+	Convey("passwordFromSources should prefer stdin to the other sources", t, func() {
+		withStdin("from stdin\r\n", func() {
+			password, err := passwordFromSources(&Settings{PasswordStdin: true, PasswordCmd: "echo from command"})
+			So(err, ShouldBeNil)
+			So(password, ShouldEqual, "from stdin")
+		})
+	})
+
+	Convey("passwordFromSources should return no password without sources", t, func() {
+		password, err := passwordFromSources(&Settings{})
+		So(err, ShouldBeNil)
+		So(password, ShouldEqual, "")
+	})
+}
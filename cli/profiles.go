@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/urfave/cli/v3"
+)
+
+// selectProfile applies the named profile of the config file on top of
+// the top-level settings, so profiles only need to list what differs
+func selectProfile(name string, s *Settings) error {
+	if _, exist := s.Profiles[name]; !exist {
+		return fmt.Errorf("profile \"%s\" not found in config file", name)
+	}
+	p, err := profileSettings(s, name)
+	if err != nil {
+		return err
+	}
+	*s = p
+	logger.Debugf("Selected profile \"%s\"\n", name)
+	return nil
+}
+
+// profileSettings returns the top-level settings with the named profile
+// applied. The fields set by the profile are cleared first, as decoding
+// over them would merge the slice elements and maps of both (and modify
+// the ones of top, which shares them).
+func profileSettings(top *Settings, name string) (Settings, error) {
+	s := *top
+	raw := top.Profiles[name]
+	var keys map[string]json.RawMessage
+	err := json.Unmarshal(raw, &keys)
+	if err == nil {
+		v := reflect.ValueOf(&s).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+			if _, set := keys[key]; set {
+				v.Field(i).SetZero()
+			}
+		}
+		err = decodeStrict(raw, &s)
+	}
+	if err != nil {
+		return s, configError(fmt.Sprintf("profile \"%s\"", name), err, locator{field: noPosition, key: noPosition})
+	}
+	return s, nil
+}
+
+// isFlag tells if arg is the flag name, as -name, --name or with =value
+func isFlag(arg, name string) bool {
+	arg, _, _ = strings.Cut(arg, "=")
+	return arg == "-"+name || arg == "--"+name
+}
+
+// profileArgs returns the arguments (without the program name) to run
+// this invocation for one profile
+func profileArgs(cf, name string, osArgs []string) []string {
+	args := []string{"--config-file", cf, "--profile", name}
+	for _, arg := range osArgs {
+		if !isFlag(arg, "all-profiles") {
+			args = append(args, arg)
+		}
+	}
+	return args
+}
+
+// runProfile runs the daemon of one profile in a child process, prefixing
+// its log with the profile name
+func runProfile(ctx context.Context, exe, cf, name string, children *sync.Map) error {
+	cmd := exec.CommandContext(ctx, exe, profileArgs(cf, name, os.Args[1:])...)
+	cmd.Cancel = func() error {
+		// Let the child log out of the keepalive gracefully
+		if runtime.GOOS == "windows" {
+			return cmd.Process.Kill()
+		}
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.Stdout = os.Stdout
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	children.Store(name, cmd.Process)
+	defer children.Delete(name)
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		fmt.Fprintf(os.Stderr, "[%s] %s\n", name, scanner.Text())
+	}
+	return cmd.Wait()
+}
+
+// profileListeners are the settings which one process at a time can use
+var profileListeners = []struct {
+	key, flag string
+	value     func(s *Settings) string
+}{
+	{"metricsListen", "metrics-listen", func(s *Settings) string { return s.MetricsListen }},
+	{"controlSocket", "control-socket", func(s *Settings) string { return s.ControlSocket }},
+	{"controlListen", "control-listen", func(s *Settings) string { return s.ControlListen }},
+}
+
+// checkProfileListeners rejects the listeners shared by several profiles,
+// which every child but the first would fail to bind. flag returns the
+// value of a flag and whether it is set; flags and environment variables
+// apply to every profile.
+func checkProfileListeners(top Settings, names []string, flag func(name string) (string, bool)) error {
+	for _, l := range profileListeners {
+		used := map[string]string{}
+		for _, name := range names {
+			s, err := profileSettings(&top, name)
+			if err != nil {
+				return err
+			}
+			value := l.value(&s)
+			if env, exist := os.LookupEnv(envName(l.key)); exist {
+				value = env
+			}
+			if v, set := flag(l.flag); set {
+				value = v
+			}
+			if len(value) == 0 {
+				continue
+			}
+			if other, exist := used[value]; exist {
+				return fmt.Errorf("profiles \"%s\" and \"%s\" both use %s \"%s\", set a different one in each profile", other, name, l.key, value)
+			}
+			used[value] = name
+		}
+	}
+	return nil
+}
+
+// runAllProfiles runs the daemon of every profile concurrently, until all
+// of them exit or ctx is done
+func runAllProfiles(ctx context.Context, c *cli.Command) error {
+	if c.IsSet("profile") {
+		return fmt.Errorf("--profile and --all-profiles cannot be used together")
+	}
+	cf := locateConfigFile(c)
+	if len(cf) == 0 {
+		return fmt.Errorf("cannot find config file (it is necessary with --all-profiles)")
+	}
+	if err := parseSettingsFile(cf, &settings); err != nil {
+		return err
+	}
+	if len(settings.Profiles) == 0 {
+		return fmt.Errorf("no profiles in config file \"%s\"", cf)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(settings.Profiles))
+	for name := range settings.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	err = checkProfileListeners(settings, names, func(name string) (string, bool) {
+		return c.String(name), c.IsSet(name)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Forward SIGHUP to the profiles to reload them
+	var children sync.Map
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer func() {
+		// Ends the forwarding once the children exited
+		signal.Stop(hup)
+		close(hup)
+	}()
+	go func() {
+		for range hup {
+			children.Range(func(_, p any) bool {
+				_ = p.(*os.Process).Signal(syscall.SIGHUP)
+				return true
+			})
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := []string{}
+	for _, name := range names {
+		logger.Infof("Starting profile \"%s\"\n", name)
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if err := runProfile(ctx, exe, cf, name, &children); err != nil && ctx.Err() == nil {
+				logger.Errorf("Profile \"%s\" exited: %s\n", name, err)
+				mu.Lock()
+				failed = append(failed, name)
+				mu.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+	if len(failed) != 0 {
+		return fmt.Errorf("profiles %v failed", failed)
+	}
+	return nil
+}
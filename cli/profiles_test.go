@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/z4yx/GoAuthing/notify"
+)
+
+func TestProfileArgs(t *testing.T) {
+	Convey("profileArgs should strip --all-profiles in every form", t, func() {
+		for _, flag := range []string{"--all-profiles", "-all-profiles", "--all-profiles=true", "-all-profiles=1"} {
+			args := profileArgs("/etc/goauthing.json", "lab", []string{"-D", flag, "daemon"})
+			So(args, ShouldResemble, []string{"--config-file", "/etc/goauthing.json", "--profile", "lab", "-D", "daemon"})
+		}
+	})
+
+	Convey("profileArgs should keep other flags", t, func() {
+		args := profileArgs("/etc/goauthing.json", "lab", []string{"--all-profiles-x", "daemon", "--all-profiles"})
+		So(args, ShouldResemble, []string{"--config-file", "/etc/goauthing.json", "--profile", "lab", "--all-profiles-x", "daemon"})
+	})
+}
+
+func TestCheckProfileListeners(t *testing.T) {
+	noFlags := func(string) (string, bool) { return "", false }
+	profiles := func(raw ...string) map[string]json.RawMessage {
+		m := map[string]json.RawMessage{}
+		for i, r := range raw {
+			m[string(rune('a'+i))] = json.RawMessage(r)
+		}
+		return m
+	}
+	names := []string{"a", "b"}
+
+	Convey("Profiles with their own listeners should be accepted", t, func() {
+		top := Settings{Profiles: profiles(`{"metricsListen": ":9417", "controlSocket": "/run/a.sock"}`, `{"metricsListen": ":9418"}`)}
+		So(checkProfileListeners(top, names, noFlags), ShouldBeNil)
+	})
+
+	Convey("Listeners shared by profiles should be rejected", t, func() {
+		top := Settings{MetricsListen: ":9417", Profiles: profiles(`{}`, `{"username": "bob"}`)}
+		err := checkProfileListeners(top, names, noFlags)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, `profiles "a" and "b" both use metricsListen ":9417"`)
+
+		top = Settings{Profiles: profiles(`{"controlListen": "127.0.0.1:9418"}`, `{"controlListen": "127.0.0.1:9418"}`)}
+		So(checkProfileListeners(top, names, noFlags), ShouldNotBeNil)
+	})
+
+	Convey("Listeners given by flags or the environment apply to every profile", t, func() {
+		top := Settings{Profiles: profiles(`{"controlSocket": "/run/a.sock"}`, `{"controlSocket": "/run/b.sock"}`)}
+		flag := func(name string) (string, bool) { return "/run/auth-thu.sock", name == "control-socket" }
+		So(checkProfileListeners(top, names, flag), ShouldNotBeNil)
+
+		t.Setenv("AUTH_THU_METRICS_LISTEN", ":9417")
+		top = Settings{Profiles: profiles(`{"metricsListen": ":9418"}`, `{"metricsListen": ":9419"}`)}
+		So(checkProfileListeners(top, names, noFlags), ShouldNotBeNil)
+	})
+}
+
+func TestSelectProfile(t *testing.T) {
+	newTop := func() Settings {
+		return Settings{
+			Username:         "alice",
+			KeepAliveTargets: []ProbeTarget{{Type: "http", Address: "https://www.tsinghua.edu.cn/", ExpectStatus: []int{204}}},
+			Webhooks:         []notify.Webhook{{URL: "https://example.com/hook", Headers: map[string]string{"Authorization": "Bearer top"}}},
+			Profiles: map[string]json.RawMessage{
+				"lab": json.RawMessage(`{"keepAliveTargets": [{"type": "tcp", "address": "166.111.4.100:443"}], "webhooks": [{"url": "https://example.com/lab", "headers": {"X-Token": "lab"}}]}`),
+				"bob": json.RawMessage(`{"username": "bob"}`),
+			},
+		}
+	}
+
+	Convey("selectProfile should replace the slices and maps set by the profile", t, func() {
+		top := newTop()
+		s := top
+		So(selectProfile("lab", &s), ShouldBeNil)
+		So(s.Username, ShouldEqual, "alice")
+		So(s.KeepAliveTargets, ShouldResemble, []ProbeTarget{{Type: "tcp", Address: "166.111.4.100:443"}})
+		So(s.Webhooks, ShouldHaveLength, 1)
+		So(s.Webhooks[0].URL, ShouldEqual, "https://example.com/lab")
+		So(s.Webhooks[0].Headers, ShouldResemble, map[string]string{"X-Token": "lab"})
+
+		So(top, ShouldResemble, newTop())
+	})
+
+	Convey("selectProfile should keep the slices and maps not set by the profile", t, func() {
+		s := newTop()
+		So(selectProfile("bob", &s), ShouldBeNil)
+		So(s.Username, ShouldEqual, "bob")
+		So(s.KeepAliveTargets, ShouldResemble, newTop().KeepAliveTargets)
+		So(s.Webhooks, ShouldResemble, newTop().Webhooks)
+	})
+
+	Convey("selectProfile should reject unknown profiles", t, func() {
+		s := newTop()
+		So(selectProfile("nobody", &s), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"github.com/urfave/cli/v3"
+)
+
+// keepAliveChanged tells if keepAliveLoop (or the daemon) has to restart
+// to apply the new settings
+func keepAliveChanged(a, b *Settings) bool {
+	return a.OnIntrvl != b.OnIntrvl ||
+		a.OnRetry != b.OnRetry ||
+		a.CheckIntrvl != b.CheckIntrvl ||
+		a.Campus != b.Campus ||
+		!reflect.DeepEqual(a.KeepAliveTargets, b.KeepAliveTargets) ||
+		a.V6Target != b.V6Target ||
+		a.V6Intrvl != b.V6Intrvl
+}
+
+// authChanged tells if the session has to be re-authenticated with the
+// new settings
+func authChanged(a, b *Settings) bool {
+	return a.Username != b.Username ||
+		a.Password != b.Password ||
+		a.Host != b.Host ||
+		a.Ip != b.Ip ||
+		a.AcID != b.AcID ||
+		a.V6 != b.V6 ||
+		a.Insecure != b.Insecure ||
+		a.Campus != b.Campus
+}
+
+// reloadSettings parses the config file, environment and flags again into
+// new settings, and publishes them as a whole if they are valid. The hook,
+// webhook and log settings apply as they are; keepAliveLoop (or the
+// daemon) restarts if its settings changed; and the session is logged out
+// and in again only if the credentials or the auth server changed.
+func reloadSettings(ctx context.Context, c *cli.Command) (string, error) {
+	old := *snapshot()
+	// authUtil appends the suffix to the setting
+	if old.Campus {
+		old.Username = strings.TrimSuffix(old.Username, "@tsinghua")
+	}
+	s, err := loadSettings(c)
+	if err == nil && len(s.Password) == 0 && !s.PasswordStdin {
+		s.Password, err = passwordFromSources(&s)
+	}
+	if err != nil {
+		return "", err
+	}
+	// The password can't be read from stdin or the prompt again
+	if len(s.Password) == 0 && s.Username == old.Username {
+		s.Password = old.Password
+	}
+	if len(s.Password) == 0 {
+		s.Password = keyringPassword(&s)
+	}
+	if s.MetricsListen != old.MetricsListen || s.ControlSocket != old.ControlSocket || s.ControlListen != old.ControlListen {
+		logger.Warningf("Restart to apply the changes of metricsListen, controlSocket and controlListen\n")
+	}
+	if s.Timeout != old.Timeout {
+		logger.Warningf("Restart to apply the change of timeout\n")
+	}
+	current.Store(&s)
+	setLoggerLevel(s.Debug, s.Daemon)
+
+	applied := []string{"settings reloaded"}
+	if authChanged(&old, &s) {
+		logger.Infof("Credentials or auth server changed, logging in again\n")
+		if _, err = logoutSession(ctx, &old); err != nil {
+			logger.Errorf("Logout of the old session failed: %s\n", err)
+		}
+		// The daemon logs in by itself after restarting
+		if control.mode != "daemon" && !control.paused.Load() {
+			if _, err = loginNow(ctx); err != nil {
+				return "", err
+			}
+		}
+		applied = append(applied, "logged in again")
+		poke(control.restart)
+	} else if keepAliveChanged(&old, &s) {
+		applied = append(applied, "keepalive restarted")
+		poke(control.restart)
+	}
+	message := strings.Join(applied, ", ")
+	logger.Infof("Reload: %s\n", message)
+	return message, nil
+}
+
+// reloadOnSighup reloads the settings on SIGHUP until ctx is done
+func reloadOnSighup(ctx context.Context, c *cli.Command) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				logger.Infof("SIGHUP received, reloading the settings\n")
+				if _, err := control.reload(ctx, c); err != nil {
+					logger.Errorf("Reload failed, keeping the settings: %s\n", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+// jitter randomizes d by +/- settings.RetryJitter (a fraction of d)
+func jitter(d time.Duration) time.Duration {
+	j := snapshot().RetryJitter
+	if j <= 0 {
+		return d
+	}
+	if j > 1 {
+		j = 1
+	}
+	return time.Duration(float64(d) * (1 + j*(2*rand.Float64()-1)))
+}
+
+// retryDelay returns the delay before retry n (from 0), without jitter:
+// RetryBackoff doubled n times, up to RetryMaxBackoff
+func retryDelay(n int) time.Duration {
+	s := snapshot()
+	delay := time.Duration(s.RetryBackoff) * time.Second
+	maxDelay := time.Duration(s.RetryMaxBackoff) * time.Second
+	for i := 0; i < n; i++ {
+		delay *= 2
+		if maxDelay > 0 && delay > maxDelay {
+			return maxDelay
+		}
+	}
+	return delay
+}
+
+// withRetry calls f until it succeeds, fails with a non-transient error
+// (e.g. wrong password) or settings.LoginRetries retries are used up.
+// The delay between attempts grows exponentially up to RetryMaxBackoff.
+func withRetry(ctx context.Context, action string, f func() error) (err error) {
+	retries := snapshot().LoginRetries
+	for attempt := 0; ; attempt++ {
+		err = f()
+		observeAuth(action, err)
+		if err == nil || attempt >= retries || !libauth.IsRetryable(err) {
+			return
+		}
+		delay := jitter(retryDelay(attempt))
+		logger.Infof("%s failed (retry %d/%d in %v): %s\n", action, attempt+1, retries, delay, localize(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
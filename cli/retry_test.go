@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+// countCalls returns f failing with errs in turn (then succeeding), and
+// the number of times it was called
+func countCalls(errs ...error) (f func() error, calls *int) {
+	calls = new(int)
+	return func() error {
+		*calls++
+		if *calls <= len(errs) {
+			return errs[*calls-1]
+		}
+		return nil
+	}, calls
+}
+
+func TestWithRetry(t *testing.T) {
+	rateLimited := &libauth.PortalError{Code: "E2532"}
+	wrongPassword := &libauth.PortalError{Code: "E2553"}
+
+	Convey("withRetry should retry transient errors up to loginRetries", t, func() {
+		settings = Settings{LoginRetries: 2}
+		f, calls := countCalls(rateLimited, rateLimited, rateLimited, rateLimited)
+		err := withRetry(context.Background(), "Login", f)
+		So(errors.Is(err, libauth.ErrRateLimited), ShouldBeTrue)
+		So(*calls, ShouldEqual, 3)
+
+		f, calls = countCalls(rateLimited)
+		So(withRetry(context.Background(), "Login", f), ShouldBeNil)
+		So(*calls, ShouldEqual, 2)
+
+		settings = Settings{}
+		f, calls = countCalls(rateLimited)
+		So(withRetry(context.Background(), "Login", f), ShouldNotBeNil)
+		So(*calls, ShouldEqual, 1)
+	})
+
+	Convey("withRetry should stop at once on E2553", t, func() {
+		settings = Settings{LoginRetries: 5}
+		f, calls := countCalls(wrongPassword)
+		err := withRetry(context.Background(), "Login", f)
+		So(errors.Is(err, libauth.ErrWrongPassword), ShouldBeTrue)
+		So(*calls, ShouldEqual, 1)
+	})
+
+	Convey("withRetry should stop when cancelled", t, func() {
+		settings = Settings{LoginRetries: 5, RetryBackoff: 60}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		f, calls := countCalls(rateLimited, rateLimited)
+		So(withRetry(ctx, "Login", f), ShouldNotBeNil)
+		So(*calls, ShouldEqual, 1)
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	Convey("The delay should double up to the maximum", t, func() {
+		settings = Settings{RetryBackoff: 2, RetryMaxBackoff: 10}
+		delays := []time.Duration{}
+		for n := 0; n < 5; n++ {
+			delays = append(delays, retryDelay(n))
+		}
+		So(delays, ShouldResemble, []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second})
+
+		settings = Settings{RetryBackoff: 2}
+		So(retryDelay(10), ShouldEqual, 2048*time.Second)
+	})
+
+	Convey("Jitter should be kept in bounds", t, func() {
+		d := 10 * time.Second
+		settings = Settings{}
+		So(jitter(d), ShouldEqual, d)
+
+		settings = Settings{RetryJitter: 0.2}
+		for i := 0; i < 1000; i++ {
+			j := jitter(d)
+			So(j, ShouldBeBetweenOrEqual, 8*time.Second, 12*time.Second)
+		}
+
+		settings = Settings{RetryJitter: 5}
+		for i := 0; i < 1000; i++ {
+			So(jitter(d), ShouldBeBetweenOrEqual, time.Duration(0), 2*d)
+		}
+	})
+}
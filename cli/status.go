@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+// exitOffline is the exit code of the status command when not online
+const exitOffline = 3
+
+// statusOutput is the --json output of the status command
+type statusOutput struct {
+	Online        bool    `json:"online"`
+	Username      string  `json:"username,omitempty"`
+	OnlineIP      string  `json:"onlineIp,omitempty"`
+	OnlineIPv6    string  `json:"onlineIpv6,omitempty"`
+	LoginTime     int64   `json:"loginTime,omitempty"`
+	OnlineSeconds int64   `json:"onlineSeconds,omitempty"`
+	BytesIn       uint64  `json:"bytesIn"`
+	BytesOut      uint64  `json:"bytesOut"`
+	SumBytes      uint64  `json:"sumBytes"`
+	SumSeconds    int64   `json:"sumSeconds"`
+	Balance       float64 `json:"balance"`
+	WalletBalance float64 `json:"walletBalance"`
+	ProductName   string  `json:"productName,omitempty"`
+	BillingName   string  `json:"billingName,omitempty"`
+	OnlineDevices int     `json:"onlineDevices,omitempty"`
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func printStatus(info *libauth.UserInfo) {
+	if !info.Online {
+		fmt.Println("Currently offline!")
+		return
+	}
+	ips := []string{}
+	for _, ip := range []string{info.OnlineIP, info.OnlineIPv6} {
+		if ip != "" && ip != "::" {
+			ips = append(ips, ip)
+		}
+	}
+	fmt.Printf("Username:     %s\n", info.Username)
+	fmt.Printf("Online IP:    %s\n", strings.Join(ips, ", "))
+	if !info.LoginTime.IsZero() {
+		fmt.Printf("Online time:  %s (since %s)\n", info.OnlineDuration, info.LoginTime.Format(time.DateTime))
+	}
+	fmt.Printf("Traffic:      in %s, out %s\n", formatBytes(info.BytesIn), formatBytes(info.BytesOut))
+	fmt.Printf("This cycle:   %s, %s\n", formatBytes(info.SumBytes), info.SumDuration)
+	fmt.Printf("Balance:      %.2f\n", info.Balance+info.WalletBalance)
+	if info.ProductName != "" {
+		fmt.Printf("Product:      %s\n", info.ProductName)
+	}
+}
+
+func newStatusOutput(info *libauth.UserInfo) statusOutput {
+	out := statusOutput{
+		Online:        info.Online,
+		Username:      info.Username,
+		OnlineIP:      info.OnlineIP,
+		OnlineIPv6:    info.OnlineIPv6,
+		OnlineSeconds: int64(info.OnlineDuration / time.Second),
+		BytesIn:       info.BytesIn,
+		BytesOut:      info.BytesOut,
+		SumBytes:      info.SumBytes,
+		SumSeconds:    int64(info.SumDuration / time.Second),
+		Balance:       info.Balance,
+		WalletBalance: info.WalletBalance,
+		ProductName:   info.ProductName,
+		BillingName:   info.BillingName,
+		OnlineDevices: info.OnlineDevices,
+	}
+	if !info.LoginTime.IsZero() {
+		out.LoginTime = info.LoginTime.Unix()
+	}
+	return out
+}
+
+// userInfo converts the output back, for printStatus
+func (out *statusOutput) userInfo() *libauth.UserInfo {
+	info := &libauth.UserInfo{
+		Online:         out.Online,
+		Username:       out.Username,
+		OnlineIP:       out.OnlineIP,
+		OnlineIPv6:     out.OnlineIPv6,
+		OnlineDuration: time.Duration(out.OnlineSeconds) * time.Second,
+		BytesIn:        out.BytesIn,
+		BytesOut:       out.BytesOut,
+		SumBytes:       out.SumBytes,
+		SumDuration:    time.Duration(out.SumSeconds) * time.Second,
+		Balance:        out.Balance,
+		WalletBalance:  out.WalletBalance,
+		ProductName:    out.ProductName,
+		BillingName:    out.BillingName,
+		OnlineDevices:  out.OnlineDevices,
+	}
+	if out.LoginTime != 0 {
+		info.LoginTime = time.Unix(out.LoginTime, 0)
+	}
+	return info
+}
+
+func printStatusJSON(info *libauth.UserInfo) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newStatusOutput(info))
+}
+
+func cmdStatus(ctx context.Context, c *cli.Command) error {
+	err := parseSettings(c)
+	if err != nil {
+		logger.Errorf("Parse setting error: %s\n", err)
+		exit(1)
+	}
+	acID := "1"
+	if len(settings.AcID) != 0 {
+		acID = settings.AcID
+	}
+	client := libauth.NewClient(libauth.NewUrlProvider(portalDomain(), settings.Insecure), acID)
+
+	var info *libauth.UserInfo
+	if len(settings.Ip) != 0 {
+		info, err = client.UserInfo(ctx, settings.Ip)
+	} else {
+		info, err = client.Status(ctx)
+	}
+	if err != nil {
+		logger.Errorf("Status error: %s\n", err)
+		exit(1)
+	}
+
+	if c.Bool("json") {
+		err = printStatusJSON(info)
+	} else {
+		printStatus(info)
+	}
+	if err != nil {
+		logger.Errorf("Status error: %s\n", err)
+		exit(1)
+	}
+	if !info.Online {
+		exit(exitOffline)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/z4yx/GoAuthing/libauth"
+)
+
+// exited is the panic of osExit in runExit
+type exited int
+
+// runExit runs the command line args (without the program name) from
+// scratch, and returns the code it exits with, 0 if it returns
+func runExit(t *testing.T, args ...string) (code int) {
+	// Keep the config files of the user out
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	settings = Settings{}
+	current.Store(nil)
+
+	saved := osExit
+	osExit = func(code int) { panic(exited(code)) }
+	defer func() {
+		osExit = saved
+		if r := recover(); r != nil {
+			e, ok := r.(exited)
+			if !ok {
+				panic(r)
+			}
+			code = int(e)
+		}
+	}()
+	if err := newApp().Run(context.Background(), append([]string{"auth-thu"}, args...)); err != nil {
+		return 1
+	}
+	return 0
+}
+
+func TestFormatBytes(t *testing.T) {
+	Convey("formatBytes should use binary units", t, func() {
+		cases := map[uint64]string{
+			0:             "0 B",
+			1023:          "1023 B",
+			1024:          "1.00 KiB",
+			1536:          "1.50 KiB",
+			5 << 20:       "5.00 MiB",
+			3 << 30:       "3.00 GiB",
+			1<<40 + 1<<39: "1.50 TiB",
+		}
+		for n, s := range cases {
+			So(formatBytes(n), ShouldEqual, s)
+		}
+	})
+}
+
+func TestNewStatusOutput(t *testing.T) {
+	Convey("newStatusOutput should convert the durations and times to seconds", t, func() {
+		info := &libauth.UserInfo{
+			Online:         true,
+			Username:       "user",
+			OnlineIP:       "166.111.0.1",
+			LoginTime:      time.Unix(1700000000, 0),
+			OnlineDuration: 90 * time.Minute,
+			BytesIn:        2048,
+			SumDuration:    10 * time.Hour,
+			Balance:        12.5,
+		}
+		out := newStatusOutput(info)
+		So(out.Online, ShouldBeTrue)
+		So(out.Username, ShouldEqual, "user")
+		So(out.LoginTime, ShouldEqual, 1700000000)
+		So(out.OnlineSeconds, ShouldEqual, 5400)
+		So(out.SumSeconds, ShouldEqual, 36000)
+		So(out.BytesIn, ShouldEqual, 2048)
+		So(out.userInfo(), ShouldResemble, info)
+	})
+
+	Convey("newStatusOutput should leave the login time out when offline", t, func() {
+		out := newStatusOutput(&libauth.UserInfo{})
+		So(out.Online, ShouldBeFalse)
+		So(out.LoginTime, ShouldEqual, 0)
+		So(out.userInfo().LoginTime.IsZero(), ShouldBeTrue)
+	})
+}
+
+func TestStatusExitCode(t *testing.T) {
+	portal := &fakeAuthServer{}
+	srv := httptest.NewServer(portal)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	args := []string{"status", "--host", host, "--insecure", "--ac-id", "1", "--json"}
+
+	Convey("status should exit with exitOffline when offline", t, func() {
+		So(runExit(t, args...), ShouldEqual, exitOffline)
+	})
+
+	Convey("status should succeed when online", t, func() {
+		portal.mu.Lock()
+		portal.online, portal.user = true, "user"
+		portal.mu.Unlock()
+		So(runExit(t, args...), ShouldEqual, 0)
+	})
+
+	Convey("status should fail if the auth server is unreachable", t, func() {
+		So(runExit(t, "status", "--host", "127.0.0.1:1", "--insecure", "--ac-id", "1"), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,171 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// fileContent is the JSON layout of the encrypted file. Data is the JSON
+// map of user names to passwords, sealed with AES-256-GCM using a key
+// derived from the passphrase with scrypt.
+type fileContent struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// FileStore is a Store in a file encrypted with a passphrase
+type FileStore struct {
+	Path string
+	// Passphrase is called when the file is to be decrypted or created
+	// (create is true if the file does not exist yet)
+	Passphrase func(create bool) (string, error)
+
+	passphrase string
+}
+
+func (f *FileStore) String() string {
+	return fmt.Sprintf("encrypted file %s", f.Path)
+}
+
+func fileKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (f *FileStore) getPassphrase(create bool) (err error) {
+	if len(f.passphrase) == 0 {
+		f.passphrase, err = f.Passphrase(create)
+		if err == nil && len(f.passphrase) == 0 {
+			err = fmt.Errorf("passphrase can't be empty")
+		}
+	}
+	return
+}
+
+// load decrypts the file, which is empty if it does not exist
+func (f *FileStore) load() (map[string]string, error) {
+	passwords := map[string]string{}
+	b, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return passwords, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var content fileContent
+	if err = json.Unmarshal(b, &content); err != nil {
+		return nil, fmt.Errorf("parse %s failed (%s)", f.Path, err)
+	}
+	if err = f.getPassphrase(false); err != nil {
+		return nil, err
+	}
+	aead, err := fileKey(f.passphrase, content.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(content.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("parse %s failed (bad nonce)", f.Path)
+	}
+	plain, err := aead.Open(nil, content.Nonce, content.Data, nil)
+	if err != nil {
+		f.passphrase = ""
+		return nil, fmt.Errorf("decrypt %s failed (wrong passphrase?)", f.Path)
+	}
+	if err = json.Unmarshal(plain, &passwords); err != nil {
+		return nil, fmt.Errorf("parse %s failed (%s)", f.Path, err)
+	}
+	return passwords, nil
+}
+
+// save encrypts passwords with a new salt and nonce, and replaces the file
+func (f *FileStore) save(passwords map[string]string) error {
+	_, err := os.Stat(f.Path)
+	if err = f.getPassphrase(errors.Is(err, os.ErrNotExist)); err != nil {
+		return err
+	}
+	content := fileContent{Salt: make([]byte, 16)}
+	if _, err = rand.Read(content.Salt); err != nil {
+		return err
+	}
+	aead, err := fileKey(f.passphrase, content.Salt)
+	if err != nil {
+		return err
+	}
+	content.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(content.Nonce); err != nil {
+		return err
+	}
+	plain, err := json.Marshal(passwords)
+	if err != nil {
+		return err
+	}
+	content.Data = aead.Seal(nil, content.Nonce, plain, nil)
+	b, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(f.Path), 0700); err != nil {
+		return err
+	}
+	tmp := f.Path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.Path)
+}
+
+func (f *FileStore) Get(user string) (string, error) {
+	if _, err := os.Stat(f.Path); errors.Is(err, os.ErrNotExist) {
+		// Don't ask for the passphrase of a file never created
+		return "", ErrNotFound
+	}
+	passwords, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	password, exist := passwords[user]
+	if !exist {
+		return "", ErrNotFound
+	}
+	return password, nil
+}
+
+func (f *FileStore) Set(user, password string) error {
+	passwords, err := f.load()
+	if err != nil {
+		return err
+	}
+	passwords[user] = password
+	return f.save(passwords)
+}
+
+func (f *FileStore) Delete(user string) error {
+	if _, err := os.Stat(f.Path); errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	passwords, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, exist := passwords[user]; !exist {
+		return ErrNotFound
+	}
+	delete(passwords, user)
+	return f.save(passwords)
+}
@@ -0,0 +1,50 @@
+package keyring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFileStore(t *testing.T) {
+	Convey("FileStore should keep passwords encrypted", t, func() {
+		path := filepath.Join(t.TempDir(), "sub", "credentials")
+		asked := 0
+		store := &FileStore{Path: path, Passphrase: func(create bool) (string, error) {
+			asked++
+			return "correct horse", nil
+		}}
+
+		_, err := store.Get("alice")
+		So(err, ShouldEqual, ErrNotFound)
+		So(asked, ShouldEqual, 0)
+
+		So(store.Set("alice", "secret1"), ShouldBeNil)
+		So(store.Set("bob", "secret2\n"), ShouldBeNil)
+		fi, err := os.Stat(path)
+		So(err, ShouldBeNil)
+		So(fi.Mode().Perm(), ShouldEqual, 0600)
+		b, _ := os.ReadFile(path)
+		So(string(b), ShouldNotContainSubstring, "secret1")
+
+		reopened := &FileStore{Path: path, Passphrase: func(bool) (string, error) { return "correct horse", nil }}
+		password, err := reopened.Get("alice")
+		So(err, ShouldBeNil)
+		So(password, ShouldEqual, "secret1")
+		password, err = reopened.Get("bob")
+		So(err, ShouldBeNil)
+		So(password, ShouldEqual, "secret2\n")
+
+		So(reopened.Delete("alice"), ShouldBeNil)
+		_, err = reopened.Get("alice")
+		So(err, ShouldEqual, ErrNotFound)
+		So(reopened.Delete("alice"), ShouldEqual, ErrNotFound)
+
+		wrong := &FileStore{Path: path, Passphrase: func(bool) (string, error) { return "wrong", nil }}
+		_, err = wrong.Get("bob")
+		So(err, ShouldNotBeNil)
+		So(err, ShouldNotEqual, ErrNotFound)
+	})
+}
@@ -0,0 +1,73 @@
+// Package keyring stores passwords in the freedesktop Secret Service
+// (GNOME Keyring, KWallet, KeePassXC...) or, where it is unavailable, in a
+// file encrypted with a passphrase.
+package keyring
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrNotFound is returned by Get and Delete if no password is stored for
+// the user
+var ErrNotFound = errors.New("password not found in keyring")
+
+// Store is a keyring holding one password per user name
+type Store interface {
+	Get(user string) (string, error)
+	Set(user, password string) error
+	Delete(user string) error
+	// String describes the keyring in messages
+	String() string
+}
+
+// fallbackStore uses primary until it times out prompting, then fallback
+type fallbackStore struct {
+	mu       sync.Mutex
+	primary  Store
+	fallback Store
+}
+
+// WithFallback returns a Store using primary, or fallback from the first
+// time primary returns ErrPromptTimeout (e.g. as nobody can unlock the
+// Secret Service on a headless machine)
+func WithFallback(primary, fallback Store) Store {
+	return &fallbackStore{primary: primary, fallback: fallback}
+}
+
+// do runs f on the current store, and again on fallback if it timed out
+func (s *fallbackStore) do(f func(Store) error) error {
+	s.mu.Lock()
+	store := s.primary
+	s.mu.Unlock()
+	err := f(store)
+	if !errors.Is(err, ErrPromptTimeout) || store == s.fallback {
+		return err
+	}
+	s.mu.Lock()
+	s.primary = s.fallback
+	s.mu.Unlock()
+	return f(s.fallback)
+}
+
+func (s *fallbackStore) Get(user string) (password string, err error) {
+	err = s.do(func(store Store) (err error) {
+		password, err = store.Get(user)
+		return
+	})
+	return
+}
+
+func (s *fallbackStore) Set(user, password string) error {
+	return s.do(func(store Store) error { return store.Set(user, password) })
+}
+
+func (s *fallbackStore) Delete(user string) error {
+	return s.do(func(store Store) error { return store.Delete(user) })
+}
+
+func (s *fallbackStore) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.primary.String()
+}
@@ -0,0 +1,234 @@
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	ssDest            = "org.freedesktop.secrets"
+	ssPath            = dbus.ObjectPath("/org/freedesktop/secrets")
+	ssIface           = "org.freedesktop.Secret.Service"
+	collectionIface   = "org.freedesktop.Secret.Collection"
+	itemIface         = "org.freedesktop.Secret.Item"
+	promptIface       = "org.freedesktop.Secret.Prompt"
+	defaultCollection = dbus.ObjectPath("/org/freedesktop/secrets/aliases/default")
+	noPrompt          = dbus.ObjectPath("/")
+)
+
+// DefaultPromptTimeout is how long SecretService waits for a prompt to be
+// answered by default
+const DefaultPromptTimeout = time.Minute
+
+// ErrPromptTimeout is returned when nobody answers a prompt of the Secret
+// Service, e.g. to unlock the keyring on a headless machine
+var ErrPromptTimeout = errors.New("prompt of Secret Service timed out")
+
+// secret is the Secret struct (oayays) of the Secret Service API
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// SecretService is a Store in the default collection of the Secret
+// Service. Items are looked up by the attributes "service" and "username".
+type SecretService struct {
+	// PromptTimeout is how long to wait for a prompt to be answered,
+	// DefaultPromptTimeout if 0
+	PromptTimeout time.Duration
+
+	conn    *dbus.Conn
+	service string
+	session dbus.ObjectPath
+}
+
+var (
+	busMu sync.Mutex
+	bus   *dbus.Conn
+)
+
+// sessionBus connects to the session bus once. Unlike dbus.SessionBus, it
+// fails if no session bus is running instead of starting one with
+// dbus-launch, which would be left running on headless machines.
+func sessionBus() (*dbus.Conn, error) {
+	busMu.Lock()
+	defer busMu.Unlock()
+	if bus != nil && bus.Connected() {
+		return bus, nil
+	}
+	conn, err := dbus.SessionBusPrivateNoAutoStartup()
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.Auth(nil); err == nil {
+		err = conn.Hello()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	bus = conn
+	return conn, nil
+}
+
+// OpenSecretService connects to the Secret Service on the session bus,
+// which must be running already
+func OpenSecretService(service string) (*SecretService, error) {
+	conn, err := sessionBus()
+	if err != nil {
+		return nil, err
+	}
+	return NewSecretService(conn, service)
+}
+
+// NewSecretService opens a session with the Secret Service on conn. The
+// "plain" algorithm is used, as the session bus is local to the user.
+func NewSecretService(conn *dbus.Conn, service string) (*SecretService, error) {
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err := conn.Object(ssDest, ssPath).Call(ssIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session)
+	if err != nil {
+		return nil, fmt.Errorf("open Secret Service session failed (%s)", err)
+	}
+	return &SecretService{conn: conn, service: service, session: session}, nil
+}
+
+func (s *SecretService) String() string {
+	return "Secret Service"
+}
+
+func (s *SecretService) attributes(user string) map[string]string {
+	return map[string]string{"service": s.service, "username": user}
+}
+
+// prompt shows a prompt (e.g. to unlock the keyring) and waits for it to
+// be answered, dismissing it after PromptTimeout
+func (s *SecretService) prompt(path dbus.ObjectPath) error {
+	if path == noPrompt || path == "" {
+		return nil
+	}
+	err := s.conn.AddMatchSignal(dbus.WithMatchObjectPath(path), dbus.WithMatchInterface(promptIface), dbus.WithMatchMember("Completed"))
+	if err != nil {
+		return err
+	}
+	defer s.conn.RemoveMatchSignal(dbus.WithMatchObjectPath(path), dbus.WithMatchInterface(promptIface), dbus.WithMatchMember("Completed"))
+	signals := make(chan *dbus.Signal, 4)
+	s.conn.Signal(signals)
+	defer s.conn.RemoveSignal(signals)
+
+	if err = s.conn.Object(ssDest, path).Call(promptIface+".Prompt", 0, "").Err; err != nil {
+		return err
+	}
+	timeout := s.PromptTimeout
+	if timeout <= 0 {
+		timeout = DefaultPromptTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case sig, ok := <-signals:
+			if !ok {
+				return fmt.Errorf("connection to Secret Service closed")
+			}
+			if sig.Path != path || sig.Name != promptIface+".Completed" {
+				continue
+			}
+			if len(sig.Body) == 0 {
+				return fmt.Errorf("invalid Completed signal of Secret Service prompt")
+			}
+			if dismissed, _ := sig.Body[0].(bool); dismissed {
+				return fmt.Errorf("prompt of Secret Service dismissed")
+			}
+			return nil
+		case <-timer.C:
+			s.conn.Object(ssDest, path).Call(promptIface+".Dismiss", 0)
+			return ErrPromptTimeout
+		}
+	}
+}
+
+func (s *SecretService) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := s.conn.Object(ssDest, ssPath).Call(ssIface+".Unlock", 0, objects).Store(&unlocked, &prompt)
+	if err != nil {
+		return err
+	}
+	return s.prompt(prompt)
+}
+
+// search returns the items of user, unlocking them if necessary
+func (s *SecretService) search(user string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	err := s.conn.Object(ssDest, ssPath).Call(ssIface+".SearchItems", 0, s.attributes(user)).Store(&unlocked, &locked)
+	if err != nil {
+		return nil, err
+	}
+	if len(locked) != 0 {
+		if err = s.unlock(locked); err != nil {
+			return nil, err
+		}
+		unlocked = append(unlocked, locked...)
+	}
+	return unlocked, nil
+}
+
+func (s *SecretService) Get(user string) (string, error) {
+	items, err := s.search(user)
+	if err != nil {
+		return "", err
+	}
+	if len(items) == 0 {
+		return "", ErrNotFound
+	}
+	var sec secret
+	err = s.conn.Object(ssDest, items[0]).Call(itemIface+".GetSecret", 0, s.session).Store(&sec)
+	if err != nil {
+		return "", err
+	}
+	return string(sec.Value), nil
+}
+
+func (s *SecretService) Set(user, password string) error {
+	if err := s.unlock([]dbus.ObjectPath{defaultCollection}); err != nil {
+		return err
+	}
+	props := map[string]dbus.Variant{
+		itemIface + ".Label":      dbus.MakeVariant(fmt.Sprintf("%s password of %s", s.service, user)),
+		itemIface + ".Attributes": dbus.MakeVariant(s.attributes(user)),
+	}
+	sec := secret{Session: s.session, Value: []byte(password), ContentType: "text/plain"}
+	var item, prompt dbus.ObjectPath
+	err := s.conn.Object(ssDest, defaultCollection).Call(collectionIface+".CreateItem", 0, props, sec, true).Store(&item, &prompt)
+	if err != nil {
+		return err
+	}
+	return s.prompt(prompt)
+}
+
+func (s *SecretService) Delete(user string) error {
+	items, err := s.search(user)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return ErrNotFound
+	}
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+		if err = s.conn.Object(ssDest, item).Call(itemIface+".Delete", 0).Store(&prompt); err != nil {
+			return err
+		}
+		if err = s.prompt(prompt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
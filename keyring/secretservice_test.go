@@ -0,0 +1,345 @@
+package keyring
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testBusConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=%s</listen>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startTestBus runs a private dbus-daemon and returns its address
+func startTestBus(t *testing.T) string {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	if err = os.WriteFile(config, []byte(fmt.Sprintf(testBusConfig, dir)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(daemon, "--config-file="+config, "--print-address=1", "--nofork")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Skip("dbus-daemon failed to start: ", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Skip("dbus-daemon failed to start: ", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+// mockSecretService implements the part of the Secret Service API used by
+// SecretService. If locked is set, the items are locked and unlocking them
+// shows a prompt which nobody answers.
+type mockSecretService struct {
+	conn   *dbus.Conn
+	mu     sync.Mutex
+	next   int
+	items  map[dbus.ObjectPath]*mockItem
+	locked bool
+	// emptyCompleted makes the prompts complete with an empty signal body
+	emptyCompleted bool
+	// dismissed counts the dismissed prompts
+	dismissed int
+}
+
+type mockItem struct {
+	service    *mockSecretService
+	path       dbus.ObjectPath
+	attributes map[string]string
+	value      []byte
+}
+
+func (m *mockSecretService) OpenSession(algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if algorithm != "plain" {
+		return dbus.MakeVariant(""), "/", dbus.MakeFailedError(fmt.Errorf("unsupported algorithm"))
+	}
+	return dbus.MakeVariant(""), "/org/freedesktop/secrets/session/1", nil
+}
+
+// search returns the items with the attributes, with m.mu held
+func (m *mockSecretService) search(attributes map[string]string) []dbus.ObjectPath {
+	found := []dbus.ObjectPath{}
+	for path, item := range m.items {
+		match := true
+		for k, v := range attributes {
+			match = match && item.attributes[k] == v
+		}
+		if match {
+			found = append(found, path)
+		}
+	}
+	return found
+}
+
+func (m *mockSecretService) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locked {
+		return []dbus.ObjectPath{}, m.search(attributes), nil
+	}
+	return m.search(attributes), []dbus.ObjectPath{}, nil
+}
+
+func (m *mockSecretService) Unlock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locked {
+		return []dbus.ObjectPath{}, mockPromptPath, nil
+	}
+	return objects, "/", nil
+}
+
+func (m *mockSecretService) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.items)
+}
+
+const mockPromptPath = dbus.ObjectPath("/org/freedesktop/secrets/prompt/1")
+
+// mockPrompt never completes, as if nobody could answer it, unless
+// emptyCompleted is set
+type mockPrompt struct{ *mockSecretService }
+
+func (p mockPrompt) Prompt(windowID string) *dbus.Error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.emptyCompleted {
+		p.conn.Emit(mockPromptPath, promptIface+".Completed")
+	}
+	return nil
+}
+
+func (p mockPrompt) Dismiss() *dbus.Error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dismissed++
+	return nil
+}
+
+// mockCollection is the default collection
+type mockCollection struct{ *mockSecretService }
+
+func (c mockCollection) CreateItem(props map[string]dbus.Variant, sec secret, replace bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	attributes, ok := props[itemIface+".Attributes"].Value().(map[string]string)
+	if !ok {
+		return "/", "/", dbus.MakeFailedError(fmt.Errorf("bad attributes"))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if replace {
+		for _, path := range c.search(attributes) {
+			c.items[path].deleteLocked()
+		}
+	}
+	c.next++
+	item := &mockItem{
+		service:    c.mockSecretService,
+		path:       dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/collection/login/%d", c.next)),
+		attributes: attributes,
+		value:      sec.Value,
+	}
+	c.items[item.path] = item
+	c.conn.Export(item, item.path, itemIface)
+	return item.path, "/", nil
+}
+
+func (i *mockItem) GetSecret(session dbus.ObjectPath) (secret, *dbus.Error) {
+	return secret{Session: session, Value: i.value, ContentType: "text/plain"}, nil
+}
+
+func (i *mockItem) Delete() (dbus.ObjectPath, *dbus.Error) {
+	i.service.mu.Lock()
+	defer i.service.mu.Unlock()
+	i.deleteLocked()
+	return "/", nil
+}
+
+// deleteLocked deletes the item, with the service mu held
+func (i *mockItem) deleteLocked() {
+	delete(i.service.items, i.path)
+	i.service.conn.Export(nil, i.path, itemIface)
+}
+
+func TestSecretService(t *testing.T) {
+	address := startTestBus(t)
+	serviceConn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serviceConn.Close()
+	mock := &mockSecretService{conn: serviceConn, items: map[dbus.ObjectPath]*mockItem{}}
+	serviceConn.Export(mock, ssPath, ssIface)
+	serviceConn.Export(mockCollection{mock}, defaultCollection, collectionIface)
+	serviceConn.Export(mockPrompt{mock}, mockPromptPath, promptIface)
+	if reply, err := serviceConn.RequestName(ssDest, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatal("cannot own ", ssDest, err)
+	}
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	Convey("SecretService should store passwords in the default collection", t, func() {
+		store, err := NewSecretService(conn, "auth-thu")
+		So(err, ShouldBeNil)
+
+		_, err = store.Get("alice")
+		So(err, ShouldEqual, ErrNotFound)
+
+		So(store.Set("alice", "secret1"), ShouldBeNil)
+		So(store.Set("alice", "secret2"), ShouldBeNil)
+		So(store.Set("bob", "secret3"), ShouldBeNil)
+		So(mock.count(), ShouldEqual, 2)
+
+		password, err := store.Get("alice")
+		So(err, ShouldBeNil)
+		So(password, ShouldEqual, "secret2")
+
+		So(store.Delete("alice"), ShouldBeNil)
+		_, err = store.Get("alice")
+		So(err, ShouldEqual, ErrNotFound)
+		So(store.Delete("alice"), ShouldEqual, ErrNotFound)
+
+		other, err := NewSecretService(conn, "other")
+		So(err, ShouldBeNil)
+		_, err = other.Get("bob")
+		So(err, ShouldEqual, ErrNotFound)
+	})
+
+	Convey("SecretService should give up prompts nobody answers", t, func() {
+		store, err := NewSecretService(conn, "auth-thu")
+		So(err, ShouldBeNil)
+		store.PromptTimeout = 100 * time.Millisecond
+		So(store.Set("carol", "secret4"), ShouldBeNil)
+
+		mock.mu.Lock()
+		mock.locked = true
+		mock.mu.Unlock()
+		defer func() {
+			mock.mu.Lock()
+			mock.locked = false
+			mock.mu.Unlock()
+		}()
+
+		_, err = store.Get("carol")
+		So(err, ShouldEqual, ErrPromptTimeout)
+		mock.mu.Lock()
+		So(mock.dismissed, ShouldEqual, 1)
+		mock.mu.Unlock()
+
+		file := &FileStore{Path: filepath.Join(t.TempDir(), "credentials"), Passphrase: func(bool) (string, error) {
+			return "correct horse", nil
+		}}
+		So(file.Set("carol", "secret5"), ShouldBeNil)
+		fallback := WithFallback(store, file)
+		So(fallback.String(), ShouldEqual, "Secret Service")
+		password, err := fallback.Get("carol")
+		So(err, ShouldBeNil)
+		So(password, ShouldEqual, "secret5")
+		So(fallback.String(), ShouldEqual, file.String())
+	})
+
+	Convey("SecretService should reject a Completed signal without body", t, func() {
+		store, err := NewSecretService(conn, "auth-thu")
+		So(err, ShouldBeNil)
+		So(store.Set("dave", "secret6"), ShouldBeNil)
+
+		mock.mu.Lock()
+		mock.locked, mock.emptyCompleted = true, true
+		mock.mu.Unlock()
+		defer func() {
+			mock.mu.Lock()
+			mock.locked, mock.emptyCompleted = false, false
+			mock.mu.Unlock()
+		}()
+
+		_, err = store.Get("dave")
+		So(err, ShouldNotBeNil)
+		So(err, ShouldNotEqual, ErrPromptTimeout)
+	})
+
+	Convey("OpenSecretService should connect to the running session bus", t, func() {
+		t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+		resetSessionBus()
+		defer resetSessionBus()
+		store, err := OpenSecretService("auth-thu")
+		So(err, ShouldBeNil)
+		password, err := store.Get("bob")
+		So(err, ShouldBeNil)
+		So(password, ShouldEqual, "secret3")
+	})
+}
+
+// resetSessionBus closes the connection of sessionBus
+func resetSessionBus() {
+	busMu.Lock()
+	defer busMu.Unlock()
+	if bus != nil {
+		bus.Close()
+		bus = nil
+	}
+}
+
+func TestOpenSecretServiceNoBus(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("dbus-launch is used on Linux only")
+	}
+	if u, err := user.Current(); err == nil {
+		if _, err = os.Stat(filepath.Join("/run/user", u.Uid, "bus")); err == nil {
+			t.Skip("a session bus is running")
+		}
+	}
+	// A dbus-launch which records that it ran
+	dir := t.TempDir()
+	launched := filepath.Join(dir, "launched")
+	script := "#!/bin/sh\ntouch " + launched + "\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(dir, "dbus-launch"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "")
+	resetSessionBus()
+
+	Convey("OpenSecretService should fail without launching a session bus", t, func() {
+		_, err := OpenSecretService("auth-thu")
+		So(err, ShouldNotBeNil)
+		_, err = os.Stat(launched)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
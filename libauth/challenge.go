@@ -0,0 +1,90 @@
+package libauth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Challenge is the reply of get_challenge. The token is required to encode
+// a login/logout request, and may be reused until it expires.
+type Challenge struct {
+	// Token is the challenge string
+	Token string
+	// Username and IP are the parameters this challenge was issued for
+	Username string
+	IP       string
+	// ClientIP is the IP address the portal sees the request from
+	ClientIP string
+	// OnlineIP is the IP address to be authenticated
+	OnlineIP string
+	// Expire is the validity reported by the portal
+	Expire time.Duration
+	// Expires is when the token becomes invalid, zero if unknown
+	Expires time.Time
+	// ServerVersion is the version string of the srun portal
+	ServerVersion string
+}
+
+// Valid tells if the token can still be used for username on ip
+func (ch *Challenge) Valid(username, ip string) bool {
+	return ch != nil && ch.Token != "" &&
+		ch.Username == username && ch.IP == ip &&
+		time.Now().Before(ch.Expires)
+}
+
+func parseChallenge(body string) (ch *Challenge, err error) {
+	f, err := decodePortalFields(body)
+	if err != nil {
+		return
+	}
+	res := f.str("res")
+	if res != "ok" {
+		err = errors.New("Failed to get challenge: " + res)
+		return
+	}
+	ch = &Challenge{
+		Token:         f.str("challenge"),
+		ClientIP:      f.str("client_ip"),
+		OnlineIP:      f.str("online_ip"),
+		Expire:        time.Duration(f.float("expire")) * time.Second,
+		ServerVersion: f.str("srun_ver"),
+	}
+	if ch.Token == "" {
+		err = errors.New("No challenge field")
+		return
+	}
+	if ch.Expire > 0 {
+		ch.Expires = time.Now().Add(ch.Expire)
+	}
+	return
+}
+
+// Challenge requests a challenge token, which is required to encode
+// the login/logout request of username on anotherIP.
+func (c *Client) Challenge(ctx context.Context, username, anotherIP string) (ch *Challenge, err error) {
+	c.Logger.Debugf("Getting challenge...\n")
+	body, err := c.getJSON(ctx, c.Host.ChallengeUriBase(), buildChallengeParams(username, anotherIP))
+	if err != nil {
+		return
+	}
+	c.Logger.Debugf("Challenge response: %v\n", body)
+	ch, err = parseChallenge(body)
+	if err != nil {
+		return
+	}
+	ch.Username = username
+	ch.IP = anotherIP
+	return
+}
+
+// GetChallenge requests a challenge token for username on ip from host.
+func GetChallenge(host *UrlProvider, username, ip string) (*Challenge, error) {
+	return GetChallengeContext(context.Background(), host, username, ip)
+}
+
+// GetChallengeContext is like GetChallenge, but aborts the request once ctx
+// is done.
+func GetChallengeContext(ctx context.Context, host *UrlProvider, username, ip string) (*Challenge, error) {
+	return NewClient(host, "").Challenge(ctx, username, ip)
+}
@@ -0,0 +1,235 @@
+package libauth
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/juju/loggo"
+)
+
+// Client talks to one srun4000 portal. Unlike the package level functions,
+// every Client carries its own HTTP client and logger, so callers embedding
+// libauth may use different timeouts, transports or proxies concurrently.
+// All requests of a Client are aborted once the passed context is done.
+type Client struct {
+	// Host provides the portal URLs
+	Host *UrlProvider
+	// AcID is the ac_id parameter sent to the portal
+	AcID string
+	// HttpClient is used for all requests. Custom transports (proxies,
+	// source address binding...) are set via its Transport field.
+	// If nil, a new http.Client with HttpTimeout is created per request.
+	HttpClient *http.Client
+	// Logger receives debug messages of this Client
+	Logger loggo.Logger
+}
+
+// NewClient creates a Client for the given portal and ac_id, logging to
+// the "libauth" logger.
+func NewClient(host *UrlProvider, acID string) *Client {
+	return &Client{
+		Host:   host,
+		AcID:   acID,
+		Logger: logger,
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HttpClient != nil {
+		return c.HttpClient
+	}
+	return &http.Client{
+		Timeout: HttpTimeout,
+	}
+}
+
+// get issues a GET request which is aborted once ctx is done
+func (c *Client) get(ctx context.Context, netClient *http.Client, url string) (*http.Response, error) {
+	c.Logger.Debugf("GET \"%s\"\n", url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return netClient.Do(req)
+}
+
+func (c *Client) getJSON(ctx context.Context, baseUrl string, params url.Values) (string, error) {
+	body, _, err := c.getJSONStatus(ctx, baseUrl, params)
+	return body, err
+}
+
+// getJSONStatus is like getJSON, but also returns the HTTP status code
+func (c *Client) getJSONStatus(ctx context.Context, baseUrl string, params url.Values) (string, int, error) {
+	const CB = "C_a_l_l_b_a_c_k"
+	params.Set("callback", CB)
+	resp, err := c.get(ctx, c.httpClient(), baseUrl+"?"+params.Encode())
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", resp.StatusCode, err
+	}
+	payload, err := extractJSONFromJSONP(string(body), CB)
+	return payload, resp.StatusCode, err
+}
+
+// DetectAcID probes the ac_id of the network this machine is attached to.
+// It only works inside Tsinghua, since it requires access to
+// login.tsinghua.edu.cn or mirrors6.tuna.tsinghua.edu.cn.
+func (c *Client) DetectAcID(ctx context.Context, V6 bool) (acID string, err error) {
+	c.Logger.Debugf("Get AC ID\n")
+	netClient := *c.httpClient()
+	netClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		c.Logger.Debugf("REDIRECT \"%v\"\n", req.URL)
+		return errors.New("should not redirect")
+	}
+	var resp *http.Response
+	var body []byte
+	url := "http://login.tsinghua.edu.cn/index_1.html"
+	if V6 {
+		url = "http://mirrors6.tuna.tsinghua.edu.cn/"
+	}
+	resp, err = c.get(ctx, &netClient, url)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	regexMatchAcID := regexp.MustCompile(`(ac_id=|index_)([0-9]+)`)
+	matches := regexMatchAcID.FindStringSubmatch(string(body))
+	if len(matches) < 3 {
+		err = errors.New("ac_id not found")
+		return
+	}
+	acID = matches[2]
+	c.Logger.Debugf("ac_id=%s\n", acID)
+	return
+}
+
+// LoginResult is the reply of a successful login/logout request
+type LoginResult struct {
+	// Username is the account name reported by the portal
+	Username string
+	// RealName is the name of the account owner
+	RealName string
+	// ClientIP is the IP address the portal sees the request from
+	ClientIP string
+	// OnlineIP is the IP address which has been authenticated
+	OnlineIP string
+	// SucMsg is the result message, e.g. "login_ok" or "logout_ok"
+	SucMsg string
+	// PloyMsg is the message of the control policy
+	PloyMsg string
+	// ServerVersion is the version string of the srun portal
+	ServerVersion string
+	// Extra holds the fields not recognized above
+	Extra map[string]interface{}
+}
+
+// parseLoginResponse returns the result of a successful request,
+// or a *PortalError describing the failure
+func parseLoginResponse(body string, httpStatus int) (result *LoginResult, err error) {
+	f, err := decodePortalFields(body)
+	if err != nil {
+		return
+	}
+	res, valid := f["error"].(string)
+	if !valid {
+		err = errors.New("No error field")
+		return
+	}
+	delete(f, "error")
+
+	if res != "ok" {
+		ecode := f.str("ecode")
+		if ecode == "0" {
+			ecode = ""
+		}
+		pe := &PortalError{
+			Code:       ecode,
+			Message:    res,
+			RawError:   res,
+			ErrorMsg:   f.str("error_msg"),
+			PloyMsg:    f.str("ploy_msg"),
+			HTTPStatus: httpStatus,
+			ClientIP:   f.str("client_ip"),
+			OnlineIP:   f.str("online_ip"),
+		}
+		if msg, exist := LookupMessage(DefaultLanguage, ecode); exist {
+			pe.Message = msg
+		}
+		err = pe
+		return
+	}
+
+	result = &LoginResult{
+		Username:      f.str("username"),
+		RealName:      f.str("real_name"),
+		ClientIP:      f.str("client_ip"),
+		OnlineIP:      f.str("online_ip"),
+		SucMsg:        f.str("suc_msg"),
+		PloyMsg:       f.str("ploy_msg"),
+		ServerVersion: f.str("srun_ver"),
+		Extra:         f,
+	}
+	return
+}
+
+// Login authenticates username on anotherIP, or on the IP of this machine
+// if anotherIP is empty.
+func (c *Client) Login(ctx context.Context, username, password, anotherIP string) (*LoginResult, error) {
+	return c.loginLogout(ctx, nil, username, password, false, anotherIP)
+}
+
+// LoginWithChallenge is like Login, but uses ch instead of requesting a new
+// challenge, as long as ch is still valid for username on anotherIP.
+func (c *Client) LoginWithChallenge(ctx context.Context, ch *Challenge, username, password, anotherIP string) (*LoginResult, error) {
+	return c.loginLogout(ctx, ch, username, password, false, anotherIP)
+}
+
+// Logout de-authenticates username on anotherIP, or on the IP of this
+// machine if anotherIP is empty.
+func (c *Client) Logout(ctx context.Context, username, anotherIP string) (*LoginResult, error) {
+	return c.loginLogout(ctx, nil, username, "", true, anotherIP)
+}
+
+// LogoutWithChallenge is like Logout, but uses ch instead of requesting a
+// new challenge, as long as ch is still valid for username on anotherIP.
+func (c *Client) LogoutWithChallenge(ctx context.Context, ch *Challenge, username, anotherIP string) (*LoginResult, error) {
+	return c.loginLogout(ctx, ch, username, "", true, anotherIP)
+}
+
+func (c *Client) loginLogout(ctx context.Context, ch *Challenge, username, password string, logout bool, anotherIP string) (result *LoginResult, err error) {
+	if ch.Valid(username, anotherIP) {
+		c.Logger.Debugf("Reusing challenge valid until %v\n", ch.Expires)
+	} else {
+		ch, err = c.Challenge(ctx, username, anotherIP)
+		if err != nil {
+			return
+		}
+	}
+
+	loginParams, err := buildLoginParams(username, password, ch.Token, logout, anotherIP, c.AcID)
+	if err != nil {
+		return
+	}
+	c.Logger.Debugf("Sending login request...\n")
+	body, status, err := c.getJSONStatus(ctx, c.Host.LoginUriBase(), loginParams)
+	if err != nil {
+		if status != 0 && (status < 200 || status > 299) {
+			err = &PortalError{Message: err.Error(), HTTPStatus: status}
+		}
+		return
+	}
+	c.Logger.Debugf("Login response: %v\n", body)
+	return parseLoginResponse(body, status)
+}
@@ -0,0 +1,95 @@
+package libauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newFakePortal starts a portal answering each path with the given JSON
+// (wrapped in the JSONP callback)
+func newFakePortal(replies map[string]string) (*httptest.Server, *UrlProvider) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/srun_portal_pc" {
+			fmt.Fprint(w, `<script>var CONFIG = { ip     : "10.0.0.1", };</script>`)
+			return
+		}
+		reply, exist := replies[r.URL.Path]
+		if !exist {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "%s(%s)", r.URL.Query().Get("callback"), reply)
+	}))
+	return srv, NewUrlProvider(strings.TrimPrefix(srv.URL, "http://"), true)
+}
+
+func TestClient(t *testing.T) {
+	Convey("Client should talk to the portal", t, func() {
+		srv, host := newFakePortal(map[string]string{
+			"/cgi-bin/get_challenge": `{"res":"ok","challenge":"aa0edd0fff7dd9f1f0ae4e981ec0114c7b0bf6f67c4895bed4f4ac634e97ecf2","client_ip":"10.0.0.2","expire":"60"}`,
+			"/cgi-bin/srun_portal":   `{"error":"ok","ecode":0,"suc_msg":"login_ok","online_ip":"10.0.0.1","sysver":"1.01"}`,
+			"/cgi-bin/rad_user_info": `{"error":"ok","user_name":"alice"}`,
+		})
+		defer srv.Close()
+		c := NewClient(host, "1")
+		ctx := context.Background()
+
+		info, err := c.Status(ctx)
+		So(err, ShouldBeNil)
+		So(info.Online, ShouldBeTrue)
+		So(info.Username, ShouldEqual, "alice")
+
+		ch, err := c.Challenge(ctx, "alice", "")
+		So(err, ShouldBeNil)
+		So(ch.Token, ShouldStartWith, "aa0edd")
+		So(ch.ClientIP, ShouldEqual, "10.0.0.2")
+		So(ch.Valid("alice", ""), ShouldBeTrue)
+		So(ch.Valid("bob", ""), ShouldBeFalse)
+		_, err = c.LoginWithChallenge(ctx, ch, "alice", "pass", "")
+		So(err, ShouldBeNil)
+
+		result, err := c.Login(ctx, "alice", "pass", "")
+		So(err, ShouldBeNil)
+		So(result.SucMsg, ShouldEqual, "login_ok")
+		So(result.OnlineIP, ShouldEqual, "10.0.0.1")
+		So(result.Extra, ShouldContainKey, "sysver")
+		_, err = c.Logout(ctx, "alice", "")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Client should report portal errors", t, func() {
+		srv, host := newFakePortal(map[string]string{
+			"/cgi-bin/get_challenge": `{"res":"ok","challenge":"0000000000000000000000000000000000000000000000000000000000000000"}`,
+			"/cgi-bin/srun_portal":   `{"error":"login_error","ecode":"E2553","error_msg":"E2553: Password is error.","client_ip":"10.0.0.2"}`,
+		})
+		defer srv.Close()
+
+		_, err := NewClient(host, "1").Login(context.Background(), "alice", "wrong", "")
+		var pe *PortalError
+		So(errors.As(err, &pe), ShouldBeTrue)
+		So(pe.Code, ShouldEqual, "E2553")
+		So(pe.RawError, ShouldEqual, "login_error")
+		So(pe.ErrorMsg, ShouldEqual, "E2553: Password is error.")
+		So(pe.ClientIP, ShouldEqual, "10.0.0.2")
+		So(pe.HTTPStatus, ShouldEqual, 200)
+	})
+
+	Convey("Client should report HTTP errors", t, func() {
+		srv, host := newFakePortal(map[string]string{
+			"/cgi-bin/get_challenge": `{"res":"ok","challenge":"0000000000000000000000000000000000000000000000000000000000000000"}`,
+		})
+		defer srv.Close()
+
+		_, err := NewClient(host, "1").Login(context.Background(), "alice", "pass", "")
+		var pe *PortalError
+		So(errors.As(err, &pe), ShouldBeTrue)
+		So(pe.HTTPStatus, ShouldEqual, 404)
+	})
+}
@@ -0,0 +1,86 @@
+package libauth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Sentinel errors classifying PortalError, e.g.
+// errors.Is(err, libauth.ErrWrongPassword)
+var (
+	ErrWrongPassword       = errors.New("wrong password")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrUserDisabled        = errors.New("user disabled")
+	ErrAlreadyOnline       = errors.New("already online")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrRateLimited         = errors.New("too frequent")
+	ErrNoControlPolicy     = errors.New("no matching product or policy")
+	ErrIPAddress           = errors.New("abnormal IP address")
+	ErrServiceUnavailable  = errors.New("authentication service unavailable")
+	// ErrKickedOffline matches the E3xxx/E4xxx codes, which tell why
+	// a session was terminated by the portal
+	ErrKickedOffline = errors.New("kicked offline")
+)
+
+var portalErrorClasses = map[string]error{
+	"E2553":                   ErrWrongPassword,
+	"E2531":                   ErrUserNotFound,
+	"E5992":                   ErrUserNotFound,
+	"E7001":                   ErrUserNotFound,
+	"E2534":                   ErrUserDisabled,
+	"E2606":                   ErrUserDisabled,
+	"E2620":                   ErrAlreadyOnline,
+	"E2616":                   ErrInsufficientBalance,
+	"E3001":                   ErrInsufficientBalance,
+	"E3004":                   ErrInsufficientBalance,
+	"E2532":                   ErrRateLimited,
+	"E2533":                   ErrRateLimited,
+	"E2806":                   ErrNoControlPolicy,
+	"E2807":                   ErrNoControlPolicy,
+	"E2808":                   ErrNoControlPolicy,
+	"E2833":                   ErrIPAddress,
+	"E2535":                   ErrServiceUnavailable,
+	"E2536":                   ErrServiceUnavailable,
+	"ip_already_online_error": ErrAlreadyOnline,
+}
+
+// Is reports whether e belongs to the class of the sentinel target
+func (e *PortalError) Is(target error) bool {
+	if target == ErrKickedOffline {
+		return strings.HasPrefix(e.Code, "E3") || strings.HasPrefix(e.Code, "E4")
+	}
+	if class, exist := portalErrorClasses[e.Code]; exist && class == target {
+		return true
+	}
+	if class, exist := portalErrorClasses[e.RawError]; exist && class == target {
+		return true
+	}
+	return false
+}
+
+// IsCredentialError tells if err is caused by the account itself, so that
+// retrying with the same username and password is pointless
+func IsCredentialError(err error) bool {
+	return errors.Is(err, ErrWrongPassword) ||
+		errors.Is(err, ErrUserNotFound) ||
+		errors.Is(err, ErrUserDisabled)
+}
+
+// IsRetryable tells if err is transient, i.e. the same request may succeed
+// later: network errors, timeouts, server errors and rate limiting
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pe *PortalError
+	if errors.As(err, &pe) {
+		return pe.HTTPStatus >= 500
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
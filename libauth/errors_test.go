@@ -0,0 +1,41 @@
+package libauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPortalErrorClasses(t *testing.T) {
+	Convey("PortalError should match its sentinel errors", t, func() {
+		err := fmt.Errorf("Login Failed: %w", &PortalError{Code: "E2553"})
+		So(errors.Is(err, ErrWrongPassword), ShouldBeTrue)
+		So(errors.Is(err, ErrRateLimited), ShouldBeFalse)
+		So(IsCredentialError(err), ShouldBeTrue)
+		So(IsRetryable(err), ShouldBeFalse)
+
+		err = &PortalError{Code: "E2532"}
+		So(errors.Is(err, ErrRateLimited), ShouldBeTrue)
+		So(IsCredentialError(err), ShouldBeFalse)
+		So(IsRetryable(err), ShouldBeTrue)
+
+		So(errors.Is(&PortalError{RawError: "ip_already_online_error"}, ErrAlreadyOnline), ShouldBeTrue)
+		So(errors.Is(&PortalError{Code: "E3004"}, ErrInsufficientBalance), ShouldBeTrue)
+		So(errors.Is(&PortalError{Code: "E3004"}, ErrKickedOffline), ShouldBeTrue)
+		So(errors.Is(&PortalError{Code: "E4101"}, ErrKickedOffline), ShouldBeTrue)
+		So(errors.Is(&PortalError{Code: "E2620"}, ErrKickedOffline), ShouldBeFalse)
+	})
+
+	Convey("Transient errors should be retryable", t, func() {
+		So(IsRetryable(&PortalError{HTTPStatus: 502}), ShouldBeTrue)
+		So(IsRetryable(&PortalError{HTTPStatus: 404}), ShouldBeFalse)
+		So(IsRetryable(&net.OpError{Op: "dial", Err: errors.New("refused")}), ShouldBeTrue)
+		So(IsRetryable(context.DeadlineExceeded), ShouldBeTrue)
+		So(IsRetryable(context.Canceled), ShouldBeFalse)
+		So(IsRetryable(nil), ShouldBeFalse)
+	})
+}
@@ -1,19 +1,101 @@
 package libauth
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
 
+// PortalError is a failure reported by the portal. Use errors.As to
+// retrieve it from the errors returned by libauth.
 type PortalError struct {
-	Code    string
+	// Code is the ecode, e.g. "E2553"
+	Code string
+	// Message is the description of Code, or RawError if Code is unknown
 	Message string
+	// RawError is the "error" field, e.g. "login_error"
+	RawError string
+	// ErrorMsg and PloyMsg are the messages returned by the portal
+	ErrorMsg string
+	PloyMsg  string
+	// HTTPStatus is the status code of the HTTP response
+	HTTPStatus int
+	// ClientIP and OnlineIP are the IP addresses reported by the portal
+	ClientIP string
+	OnlineIP string
 }
 
 func (e *PortalError) Error() string {
 	if e.Code != "" {
 		return fmt.Sprintf("%s: %s", e.Code, e.Message)
 	}
+	if e.Message == "" && e.HTTPStatus != 0 {
+		return fmt.Sprintf("HTTP status %d", e.HTTPStatus)
+	}
 	return e.Message
 }
 
+// Localize returns a copy of e with Message in the given language, e.g.
+// "en" or "zh_CN.UTF-8". The original message is kept if the code is not
+// in the catalog.
+func (e *PortalError) Localize(lang string) *PortalError {
+	l := *e
+	if msg, exist := LookupMessage(lang, e.Code); exist {
+		l.Message = msg
+	}
+	return &l
+}
+
+// DefaultLanguage is the language of PortalError messages created by libauth
+const DefaultLanguage = "zh"
+
+var (
+	catalogLock sync.RWMutex
+	// messageCatalog maps a language to the descriptions of ecodes
+	messageCatalog = map[string]map[string]string{
+		"zh": portalErrorMessages,
+		"en": portalErrorMessagesEn,
+	}
+)
+
+// normalizeLang turns locale names like "en_US.UTF-8" into "en"
+func normalizeLang(lang string) string {
+	lang = strings.ToLower(lang)
+	if i := strings.IndexAny(lang, "_-.@"); i >= 0 {
+		lang = lang[:i]
+	}
+	return lang
+}
+
+// RegisterMessages adds or overrides the descriptions of ecodes in lang,
+// e.g. for srun deployments using their own codes.
+func RegisterMessages(lang string, messages map[string]string) {
+	lang = normalizeLang(lang)
+	catalogLock.Lock()
+	defer catalogLock.Unlock()
+	catalog, exist := messageCatalog[lang]
+	if !exist {
+		catalog = map[string]string{}
+		messageCatalog[lang] = catalog
+	}
+	for code, msg := range messages {
+		catalog[code] = msg
+	}
+}
+
+// LookupMessage returns the description of code in lang, falling back to
+// DefaultLanguage and then English.
+func LookupMessage(lang, code string) (msg string, exist bool) {
+	catalogLock.RLock()
+	defer catalogLock.RUnlock()
+	for _, l := range []string{normalizeLang(lang), DefaultLanguage, "en"} {
+		if msg, exist = messageCatalog[l][code]; exist {
+			return
+		}
+	}
+	return
+}
+
 var portalErrorMessages = map[string]string{
 	"E3001": "流量或时长已用尽",
 	"E3002": "计费策略条件不匹配",
@@ -0,0 +1,104 @@
+package libauth
+
+var portalErrorMessagesEn = map[string]string{
+	"E3001": "Traffic or time quota exhausted",
+	"E3002": "Billing policy conditions not matched",
+	"E3003": "Control policy conditions not matched",
+	"E3004": "Insufficient balance",
+	"E3005": "Billing policy changed while online",
+	"E3006": "Control policy changed while online",
+	"E3007": "Timed out",
+	"E3008": "Too many connections, kicked out of the online list",
+	"E3009": "Proxy behavior detected",
+	"E3010": "Idle timeout without traffic",
+	"E3101": "Heartbeat timed out",
+	"E4001": "Disconnected by DM from the Radius table",
+	"E4002": "Disconnected by DM from the DHCP table",
+	"E4003": "Juniper IPOE COA online",
+	"E4004": "Juniper IPOE COA offline",
+	"E4005": "Disconnected by DM from the proxy table",
+	"E4006": "Bandwidth changed by COA while online",
+	"E4007": "Disconnected locally",
+	"E4008": "Virtually disconnected",
+	"E4009": "COA sent on policy switch",
+	"E4011": "Virtually disconnected on settlement",
+	"E4012": "COA sent",
+	"E4101": "Disconnected by DM from the radius module (kicked out of the online list)",
+	"E4102": "Disconnected by DM from system settings (8081)",
+	"E4103": "Disconnected by DM from the management console (8080)",
+	"E4104": "Disconnected by DM from self-service (8800)",
+	"E4112": "Disconnected locally from system settings (8081)",
+	"E4113": "Disconnected locally from the management console (8080)",
+	"E4114": "Disconnected locally from self-service (8800)",
+	"E4122": "Virtually disconnected from system settings (8081)",
+	"E4123": "Virtually disconnected from the management console (8080)",
+	"E4124": "Virtually disconnected from self-service (8800)",
+	"E2531": "User does not exist",
+	"E2532": "Interval between two authentications is too short",
+	"E2533": "Too many attempts",
+	"E2534": "Temporarily disabled due to proxy behavior",
+	"E2535": "Authentication system is closed",
+	"E2536": "System license has expired",
+	"E2553": "Wrong password",
+	"E2601": "Not the dedicated client",
+	"E2606": "User is disabled",
+	"E2611": "MAC binding error",
+	"E2612": "MAC is blacklisted",
+	"E2613": "NAS PORT binding error",
+	"E2614": "VLAN ID binding error",
+	"E2615": "IP binding error",
+	"E2616": "Account is in arrears",
+	"E2620": "Already online",
+	"E2806": "No matching product found",
+	"E2807": "No matching billing policy found",
+	"E2808": "No matching control policy found",
+	"E2833": "Abnormal IP address, please renew the address",
+	"E5990": "Incomplete data",
+	"E5991": "Invalid parameter",
+	"E5992": "User not found",
+	"E5993": "User already exists",
+	"E5001": "User created",
+	"E5002": "Failed to create user",
+	"E5010": "User modified",
+	"E5011": "Failed to modify user",
+	"E5020": "User modified",
+	"E5021": "Failed to modify user",
+	"E5030": "Group changed",
+	"E5031": "Failed to change group",
+	"E5040": "Package purchased",
+	"E5041": "Failed to purchase package",
+	"E5042": "Package not found",
+	"E5050": "MAC authentication bound",
+	"E5051": "MAC authentication unbound",
+	"E5052": "MAC bound",
+	"E5053": "MAC unbound",
+	"E5054": "NAS port bound",
+	"E5055": "NAS port unbound",
+	"E5056": "VLAN ID bound",
+	"E5057": "VLAN ID unbound",
+	"E5058": "IP bound",
+	"E5059": "IP unbound",
+	"E6001": "Payment succeeded",
+	"E6002": "Payment failed",
+	// Settlement logs
+	"E7001": "User does not exist",
+	"E7002": "Failed to add to the settlement queue",
+	"E7003": "Settlement succeeded",
+	"E7004": "Failed to add to the settled queue",
+	"E7005": "Failed to deduct the settlement amount of the product instance",
+	"E7006": "Product instance not found",
+	"E7007": "No permission to settle this user manually",
+	"E7008": "No permission to settle this product manually",
+	"E7009": "Not charged since the traffic used is below the settlement setting",
+	"E7010": "Not charged since the time used is below the settlement setting",
+	"E7011": "Not charged due to insufficient product balance, per settlement setting",
+	"E7012": "Balance set to 0 due to insufficient product balance, per settlement setting",
+	"E7013": "Balance set to negative due to insufficient product balance, per settlement setting",
+	"E7014": "Expired packages deleted",
+	"E7015": "Failed to delete expired packages",
+	"E7016": "Package purchased automatically",
+	"E7017": "Failed to purchase package automatically",
+	"E7018": "Wrong product settlement mode",
+	//
+	"vcode_error": "Wrong verification code",
+}
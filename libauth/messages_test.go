@@ -0,0 +1,23 @@
+package libauth
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLocalize(t *testing.T) {
+	Convey("PortalError should be localized", t, func() {
+		err := &PortalError{Code: "E2553", Message: "密码错误"}
+		So(err.Localize("en_US.UTF-8").Error(), ShouldEqual, "E2553: Wrong password")
+		So(err.Localize("zh_CN").Message, ShouldEqual, "密码错误")
+		So(err.Localize("fr").Message, ShouldEqual, "密码错误")
+		So(err.Message, ShouldEqual, "密码错误")
+
+		err = &PortalError{Code: "E9999", Message: "login_error"}
+		So(err.Localize("en").Message, ShouldEqual, "login_error")
+		RegisterMessages("en", map[string]string{"E9999": "Site specific error"})
+		So(err.Localize("EN").Message, ShouldEqual, "Site specific error")
+		So(err.Localize("zh").Message, ShouldEqual, "Site specific error")
+	})
+}
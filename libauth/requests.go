@@ -1,14 +1,14 @@
 package libauth
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"net/url"
-	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/juju/loggo"
@@ -16,7 +16,8 @@ import (
 
 var logger = loggo.GetLogger("libauth")
 
-// HttpTimeout is the timeout used for all HTTP requests issued by libauth.
+// HttpTimeout is the timeout used for HTTP requests issued by a Client
+// without its own HttpClient, including all package level functions.
 // It can be overridden by the caller (e.g. the CLI) before making requests.
 var HttpTimeout = 2 * time.Second
 
@@ -31,6 +32,54 @@ func extractJSONFromJSONP(jsonp, callbackName string) (string, error) {
 	return jsonp[l+1 : len(jsonp)-1], nil
 }
 
+// portalFields is a decoded portal response. Values are json.Number or
+// string, since srun is not consistent about the type of numbers.
+// The getters remove the accessed field, leaving unrecognized ones.
+type portalFields map[string]interface{}
+
+func (f portalFields) str(key string) string {
+	v, exist := f[key]
+	if !exist {
+		return ""
+	}
+	delete(f, key)
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+func (f portalFields) float(key string) float64 {
+	n, _ := strconv.ParseFloat(strings.TrimSpace(f.str(key)), 64)
+	return n
+}
+
+func (f portalFields) uint(key string) uint64 {
+	n := f.float(key)
+	if n < 0 {
+		return 0
+	}
+	return uint64(n)
+}
+
+func (f portalFields) time(key string) time.Time {
+	n := int64(f.float(key))
+	if n <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(n, 0)
+}
+
+func decodePortalFields(body string) (f portalFields, err error) {
+	d := json.NewDecoder(strings.NewReader(body))
+	d.UseNumber()
+	err = d.Decode(&f)
+	return
+}
+
 func buildChallengeParams(username string, anotherIP string) url.Values {
 
 	challParams := url.Values{
@@ -90,84 +139,28 @@ func buildLoginParams(username, password, token string, logout bool, anotherIP s
 	return
 }
 
+// GetJSON sends a JSONP request to baseUrl and returns the JSON payload.
 func GetJSON(baseUrl string, params url.Values) (string, error) {
-	const CB = "C_a_l_l_b_a_c_k"
-	params.Set("callback", CB)
-	var netClient = &http.Client{
-		Timeout: HttpTimeout,
-	}
-	url := baseUrl + "?" + params.Encode()
-	logger.Debugf("GET \"%s\"\n", url)
-	resp, err := netClient.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	return extractJSONFromJSONP(string(body), CB)
+	return GetJSONContext(context.Background(), baseUrl, params)
 }
 
-func IsOnline(host *UrlProvider, acID string) (online bool, err error, username string) {
-	logger.Debugf("Check if online\n")
-	var netClient = &http.Client{
-		Timeout: HttpTimeout,
-	}
-	online = false
-	params := url.Values{
-		"ac_id": []string{acID},
-	}
-	uri := host.OnlineCheckUriBase() + "?" + params.Encode()
-	logger.Debugf("GET \"%s\"\n", uri)
-	resp, err := netClient.Get(uri)
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
-
-	// find public ip from response
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
-	}
-	regexMatchIP := regexp.MustCompile(`ip\s+:\s"([0-9.]+)"`)
-	matches := regexMatchIP.FindStringSubmatch(string(body))
-	if len(matches) < 2 {
-		err = errors.New("ip not found")
-		return
-	}
-	ip := matches[1]
-	logger.Debugf("ip=%s\n", ip)
+// GetJSONContext is like GetJSON, but aborts the request once ctx is done.
+func GetJSONContext(ctx context.Context, baseUrl string, params url.Values) (string, error) {
+	return NewClient(nil, "").getJSON(ctx, baseUrl, params)
+}
 
-	// Get user info
-	logger.Debugf("Get user info\n")
-	params = url.Values{
-		"ip": []string{ip},
-	}
-	info, err := GetJSON(host.UserInfoUriBase(), params)
+// IsOnline checks whether this machine is online on host.
+func IsOnline(host *UrlProvider, acID string) (online bool, err error, username string) {
+	return IsOnlineContext(context.Background(), host, acID)
+}
 
-	var infoResp map[string]interface{}
-	err = json.Unmarshal([]byte(info), &infoResp)
-	logger.Debugf("Get user info %v\n", infoResp)
+// IsOnlineContext is like IsOnline, but aborts the requests once ctx is done.
+func IsOnlineContext(ctx context.Context, host *UrlProvider, acID string) (online bool, err error, username string) {
+	info, err := NewClient(host, acID).Status(ctx)
 	if err != nil {
 		return
 	}
-
-	res, valid := infoResp["error"].(string)
-	if valid && res == "ok" {
-		online = true
-		logger.Debugf("User is online\n")
-	}
-
-	res, valid = infoResp["user_name"].(string)
-	if valid {
-		username = res
-		logger.Debugf("User name is \"%s\"\n", username)
-	}
-
-	return
+	return info.Online, nil, info.Username
 }
 
 func GetNasID(IP, user, password string) (nasID string, err error) {
@@ -175,98 +168,29 @@ func GetNasID(IP, user, password string) (nasID string, err error) {
 	return
 }
 
+// GetAcID probes the ac_id of the network this machine is attached to.
 func GetAcID(V6 bool) (acID string, err error) {
-	logger.Debugf("Get AC ID\n")
-	var netClient = &http.Client{
-		Timeout: HttpTimeout,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			logger.Debugf("REDIRECT \"%v\"\n", req.URL)
-			return errors.New("should not redirect")
-		},
-	}
-	acID = ""
-	var resp *http.Response
-	var body []byte
-	url := "http://login.tsinghua.edu.cn/index_1.html"
-	if V6 {
-		url = "http://mirrors6.tuna.tsinghua.edu.cn/"
-	}
-	logger.Debugf("GET \"%s\"\n", url)
-	resp, err = netClient.Get(url)
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
-	body, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
-	}
-	regexMatchAcID := regexp.MustCompile(`(ac_id=|index_)([0-9]+)`)
-	matches := regexMatchAcID.FindStringSubmatch(string(body))
-	if len(matches) < 3 {
-		err = errors.New("ac_id not found")
-		return
-	}
-	acID = matches[2]
-	logger.Debugf("ac_id=%s\n", acID)
-	return
+	return GetAcIDContext(context.Background(), V6)
 }
 
-func LoginLogout(username, password string, host *UrlProvider, logout bool, anotherIP string, acID string) (err error) {
-	logger.Debugf("Getting challenge...\n")
-	body, err := GetJSON(host.ChallengeUriBase(), buildChallengeParams(username, anotherIP))
-	if err != nil {
-		return
-	}
-	logger.Debugf("Challenge response: %v\n", body)
-
-	var challResp map[string]interface{}
-	err = json.Unmarshal([]byte(body), &challResp)
-	if err != nil {
-		return
-	}
-	res, valid := challResp["res"].(string)
-	if !valid || res != "ok" {
-		err = errors.New("Failed to get challenge: " + res)
-		return
-	}
-	token, valid := challResp["challenge"].(string)
-	if !valid {
-		err = errors.New("No challenge field")
-		return
-	}
+// GetAcIDContext is like GetAcID, but aborts the request once ctx is done.
+func GetAcIDContext(ctx context.Context, V6 bool) (acID string, err error) {
+	return NewClient(nil, "").DetectAcID(ctx, V6)
+}
 
-	loginParams, err := buildLoginParams(username, password, token, logout, anotherIP, acID)
-	if err != nil {
-		return
-	}
-	logger.Debugf("Sending login request...\n")
-	body, err = GetJSON(host.LoginUriBase(), loginParams)
-	if err != nil {
-		return
-	}
-	logger.Debugf("Login response: %v\n", body)
-	var loginResp map[string]interface{}
-	err = json.Unmarshal([]byte(body), &loginResp)
-	if err != nil {
-		return
-	}
-	res, valid = loginResp["error"].(string)
-	if !valid {
-		err = errors.New("No error field")
-		return
-	}
+// LoginLogout logs username in or out on host.
+func LoginLogout(username, password string, host *UrlProvider, logout bool, anotherIP string, acID string) (err error) {
+	return LoginLogoutContext(context.Background(), username, password, host, logout, anotherIP, acID)
+}
 
-	if res == "ok" {
-		err = nil
+// LoginLogoutContext is like LoginLogout, but aborts the requests once ctx
+// is done.
+func LoginLogoutContext(ctx context.Context, username, password string, host *UrlProvider, logout bool, anotherIP string, acID string) (err error) {
+	c := NewClient(host, acID)
+	if logout {
+		_, err = c.Logout(ctx, username, anotherIP)
 	} else {
-		ecode, _ := loginResp["ecode"].(string)
-		if msg, exist := portalErrorMessages[ecode]; exist {
-			err = &PortalError{Code: ecode, Message: msg}
-		} else {
-			err = &PortalError{Code: ecode, Message: res}
-		}
+		_, err = c.Login(ctx, username, password, anotherIP)
 	}
-
 	return
 }
@@ -29,4 +29,4 @@ func (u *UrlProvider) ChallengeUriBase() string {
 
 func (u *UrlProvider) UserInfoUriBase() string {
 	return u.protocol + u.host + "/cgi-bin/rad_user_info"
-}
\ No newline at end of file
+}
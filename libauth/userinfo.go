@@ -0,0 +1,168 @@
+package libauth
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// UserInfo is the session information returned by rad_user_info
+type UserInfo struct {
+	// Online is true if the queried IP has an active session
+	Online bool
+	// Username is the account name of the session
+	Username string
+	// RealName is the name of the account owner
+	RealName string
+	// OnlineIP and OnlineIPv6 are the addresses the session is bound to
+	OnlineIP   string
+	OnlineIPv6 string
+	// MAC is the MAC address of the device, if known to the portal
+	MAC string
+	// BytesIn and BytesOut are the traffic of the session in bytes
+	BytesIn  uint64
+	BytesOut uint64
+	// SumBytes is the traffic of the account in the current billing cycle
+	SumBytes uint64
+	// RemainBytes is the traffic left in the current billing cycle
+	RemainBytes uint64
+	// SumDuration is the online time of the account in the current
+	// billing cycle
+	SumDuration time.Duration
+	// OnlineDuration is the time since the session was established
+	OnlineDuration time.Duration
+	// LoginTime is when the session was established
+	LoginTime time.Time
+	// KeepaliveTime is the last time the portal heard from the session
+	KeepaliveTime time.Time
+	// Balance and WalletBalance are the remaining money of the account
+	Balance       float64
+	WalletBalance float64
+	// ProductName and BillingName describe the subscribed product
+	ProductName string
+	BillingName string
+	// OnlineDevices is the number of sessions of the account
+	OnlineDevices int
+	// Extra holds the fields not recognized above
+	Extra map[string]interface{}
+}
+
+func parseUserInfo(body string) (info *UserInfo, err error) {
+	f, err := decodePortalFields(body)
+	if err != nil {
+		return
+	}
+	errField, valid := f["error"].(string)
+	if !valid {
+		err = errors.New("No error field")
+		return
+	}
+	delete(f, "error")
+
+	info = &UserInfo{
+		Online:        errField == "ok",
+		Username:      f.str("user_name"),
+		RealName:      f.str("real_name"),
+		OnlineIP:      f.str("online_ip"),
+		OnlineIPv6:    f.str("online_ip6"),
+		MAC:           f.str("user_mac"),
+		BytesIn:       f.uint("bytes_in"),
+		BytesOut:      f.uint("bytes_out"),
+		SumBytes:      f.uint("sum_bytes"),
+		RemainBytes:   f.uint("remain_bytes"),
+		SumDuration:   time.Duration(f.float("sum_seconds")) * time.Second,
+		LoginTime:     f.time("add_time"),
+		KeepaliveTime: f.time("keepalive_time"),
+		Balance:       f.float("user_balance"),
+		WalletBalance: f.float("wallet_balance"),
+		ProductName:   f.str("products_name"),
+		BillingName:   f.str("billing_name"),
+		OnlineDevices: int(f.float("online_device_total")),
+		Extra:         f,
+	}
+	if !info.LoginTime.IsZero() {
+		// Same as the portal page, which counts until the last keepalive
+		if info.KeepaliveTime.After(info.LoginTime) {
+			info.OnlineDuration = info.KeepaliveTime.Sub(info.LoginTime)
+		} else {
+			info.OnlineDuration = time.Since(info.LoginTime).Truncate(time.Second)
+		}
+	}
+	return
+}
+
+// UserInfo queries the session information of ip, or of the IP this
+// request comes from if ip is empty.
+func (c *Client) UserInfo(ctx context.Context, ip string) (info *UserInfo, err error) {
+	c.Logger.Debugf("Get user info\n")
+	params := url.Values{
+		"ip": []string{ip},
+	}
+	body, err := c.getJSON(ctx, c.Host.UserInfoUriBase(), params)
+	if err != nil {
+		return
+	}
+	c.Logger.Debugf("Get user info %s\n", body)
+	info, err = parseUserInfo(body)
+	if err != nil {
+		return
+	}
+	if info.Online {
+		c.Logger.Debugf("User is online\n")
+		c.Logger.Debugf("User name is \"%s\"\n", info.Username)
+	}
+	return
+}
+
+var regexMatchIP = regexp.MustCompile(`ip\s+:\s*"([0-9A-Fa-f.:]+)"`)
+
+// parseOnlineIP finds the IPv4 or IPv6 address of this machine in the
+// online check page
+func parseOnlineIP(body string) (string, error) {
+	matches := regexMatchIP.FindStringSubmatch(body)
+	if len(matches) < 2 || net.ParseIP(matches[1]) == nil {
+		return "", errors.New("ip not found")
+	}
+	return matches[1], nil
+}
+
+// Status queries the session information of the machine running this program.
+func (c *Client) Status(ctx context.Context) (info *UserInfo, err error) {
+	c.Logger.Debugf("Check if online\n")
+	params := url.Values{
+		"ac_id": []string{c.AcID},
+	}
+	resp, err := c.get(ctx, c.httpClient(), c.Host.OnlineCheckUriBase()+"?"+params.Encode())
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	// find public ip from response
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	ip, err := parseOnlineIP(string(body))
+	if err != nil {
+		return
+	}
+	c.Logger.Debugf("ip=%s\n", ip)
+
+	return c.UserInfo(ctx, ip)
+}
+
+// GetUserInfo queries the session information of ip on host.
+func GetUserInfo(host *UrlProvider, ip string) (*UserInfo, error) {
+	return GetUserInfoContext(context.Background(), host, ip)
+}
+
+// GetUserInfoContext is like GetUserInfo, but aborts the request once ctx
+// is done.
+func GetUserInfoContext(ctx context.Context, host *UrlProvider, ip string) (*UserInfo, error) {
+	return NewClient(host, "").UserInfo(ctx, ip)
+}
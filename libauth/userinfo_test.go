@@ -0,0 +1,69 @@
+package libauth
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseUserInfo(t *testing.T) {
+	Convey("Parsing rad_user_info of an online user...", t, func() {
+		info, err := parseUserInfo(`{"ServerFlag":0,"add_time":1700000000,"all_bytes":123,` +
+			`"billing_name":"default","bytes_in":2048,"bytes_out":"1024","error":"ok",` +
+			`"keepalive_time":1700003600,"online_device_total":"2","online_ip":"10.0.0.1",` +
+			`"products_name":"student","sum_bytes":1073741824,"sum_seconds":7200,` +
+			`"user_balance":12.5,"user_name":"alice","wallet_balance":0}`)
+		So(err, ShouldBeNil)
+		So(info.Online, ShouldBeTrue)
+		So(info.Username, ShouldEqual, "alice")
+		So(info.OnlineIP, ShouldEqual, "10.0.0.1")
+		So(info.BytesIn, ShouldEqual, 2048)
+		So(info.BytesOut, ShouldEqual, 1024)
+		So(info.SumBytes, ShouldEqual, 1<<30)
+		So(info.SumDuration, ShouldEqual, 2*time.Hour)
+		So(info.LoginTime.Unix(), ShouldEqual, 1700000000)
+		So(info.OnlineDuration, ShouldEqual, time.Hour)
+		So(info.Balance, ShouldEqual, 12.5)
+		So(info.ProductName, ShouldEqual, "student")
+		So(info.BillingName, ShouldEqual, "default")
+		So(info.OnlineDevices, ShouldEqual, 2)
+		So(info.Extra, ShouldContainKey, "ServerFlag")
+		So(info.Extra, ShouldContainKey, "all_bytes")
+		So(info.Extra, ShouldNotContainKey, "user_name")
+	})
+
+	Convey("Parsing rad_user_info of an offline user...", t, func() {
+		info, err := parseUserInfo(`{"error":"not_online_error","client_ip":"10.0.0.1"}`)
+		So(err, ShouldBeNil)
+		So(info.Online, ShouldBeFalse)
+		So(info.LoginTime.IsZero(), ShouldBeTrue)
+		So(info.Extra["client_ip"], ShouldEqual, "10.0.0.1")
+
+		_, err = parseUserInfo(`{}`)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestParseOnlineIP(t *testing.T) {
+	Convey("Parsing the online check page...", t, func() {
+		ip, err := parseOnlineIP(`<script>var CONFIG = { ip     : "10.0.0.1", };</script>`)
+		So(err, ShouldBeNil)
+		So(ip, ShouldEqual, "10.0.0.1")
+
+		ip, err = parseOnlineIP(`<script>var CONFIG = { ip     : "2402:f000:1:1501::1234", };</script>`)
+		So(err, ShouldBeNil)
+		So(ip, ShouldEqual, "2402:f000:1:1501::1234")
+
+		ip, err = parseOnlineIP(`ip : "::ffff:10.0.0.1"`)
+		So(err, ShouldBeNil)
+		So(ip, ShouldEqual, "::ffff:10.0.0.1")
+
+		_, err = parseOnlineIP(`<script>var CONFIG = { ip     : "", };</script>`)
+		So(err, ShouldNotBeNil)
+		_, err = parseOnlineIP(`<script>var CONFIG = { ip     : "cafe", };</script>`)
+		So(err, ShouldNotBeNil)
+		_, err = parseOnlineIP(`<html></html>`)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,196 @@
+// Package notify posts session events (login, logout, failure...) to
+// webhooks, e.g. of chat services.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Event is a session event, posted as JSON unless the webhook has a
+// template
+type Event struct {
+	// Action is login, relogin, logout, failure, offline-detected or
+	// keepalive-lost
+	Action   string `json:"action"`
+	Username string `json:"username"`
+	// IP is empty for the machine running auth-thu
+	IP string `json:"ip,omitempty"`
+	// Ecode and Message describe the error of failures
+	Ecode   string `json:"ecode,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Hostname is the name of the machine running auth-thu
+	Hostname string    `json:"hostname"`
+	Time     time.Time `json:"time"`
+}
+
+// Webhook is a URL to post events to
+type Webhook struct {
+	URL string `json:"url"`
+	// Events filters the actions to post, all if empty
+	Events []string `json:"events,omitempty"`
+	// Ecodes filters the failures to post by their ecode, all if empty
+	Ecodes []string `json:"ecodes,omitempty"`
+	// Template is a text/template of the body, with the Event as data and
+	// a "json" function to quote values, e.g.
+	// {"text": {{json (printf "%s: %s" .Action .Message)}}}
+	Template    string            `json:"template,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	// Retries is the times to retry on network errors and HTTP 5xx/429
+	Retries int `json:"retries,omitempty"`
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Validate checks the URL and parses the template
+func (w *Webhook) Validate() error {
+	if !strings.HasPrefix(w.URL, "http://") && !strings.HasPrefix(w.URL, "https://") {
+		return fmt.Errorf("webhook URL \"%s\" is not http(s)", w.URL)
+	}
+	if w.Retries < 0 {
+		return fmt.Errorf("retries %d of webhook %s is negative", w.Retries, w.URL)
+	}
+	if _, err := w.template(); err != nil {
+		return fmt.Errorf("template of webhook %s: %s", w.URL, err)
+	}
+	return nil
+}
+
+func (w *Webhook) template() (*template.Template, error) {
+	return template.New(w.URL).Funcs(templateFuncs).Option("missingkey=error").Parse(w.Template)
+}
+
+// Match tells whether the webhook wants the event
+func (w *Webhook) Match(ev Event) bool {
+	if len(w.Events) != 0 && !contains(w.Events, ev.Action) {
+		return false
+	}
+	if len(w.Ecodes) != 0 && len(ev.Ecode) != 0 && !contains(w.Ecodes, ev.Ecode) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Body renders the body of the event, JSON by default
+func (w *Webhook) Body(ev Event) ([]byte, error) {
+	if len(w.Template) == 0 {
+		return json.Marshal(ev)
+	}
+	t, err := w.template()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, ev); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Notifier posts events to webhooks
+type Notifier struct {
+	Webhooks []Webhook
+	// HttpClient is used for the requests, a client with a 10s timeout if nil
+	HttpClient *http.Client
+	// RetryDelay is the delay before the first retry, doubled after each
+	RetryDelay time.Duration
+}
+
+// Notify posts ev to the matching webhooks concurrently, and returns the
+// errors of the webhooks which failed after retrying
+func (n *Notifier) Notify(ctx context.Context, ev Event) error {
+	errs := make(chan error, len(n.Webhooks))
+	for i := range n.Webhooks {
+		w := &n.Webhooks[i]
+		if !w.Match(ev) {
+			errs <- nil
+			continue
+		}
+		go func() { errs <- n.post(ctx, w, ev) }()
+	}
+	all := []error{}
+	for range n.Webhooks {
+		all = append(all, <-errs)
+	}
+	return errors.Join(all...)
+}
+
+func (n *Notifier) httpClient() *http.Client {
+	if n.HttpClient != nil {
+		return n.HttpClient
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// post sends the event to one webhook, retrying transient failures
+func (n *Notifier) post(ctx context.Context, w *Webhook, ev Event) error {
+	body, err := w.Body(ev)
+	if err != nil {
+		return fmt.Errorf("webhook %s: %s", w.URL, err)
+	}
+	delay := n.RetryDelay
+	for attempt := 0; ; attempt++ {
+		var retryable bool
+		retryable, err = n.send(ctx, w, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= w.Retries {
+			return fmt.Errorf("webhook %s: %s", w.URL, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("webhook %s: %s", w.URL, err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, w *Webhook, body []byte) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	contentType := w.ContentType
+	if len(contentType) == 0 {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "auth-thu")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.httpClient().Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		retryable = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+	return false, nil
+}
@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeWebhook records the requests, failing the first `failures` of them
+type fakeWebhook struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	f.bodies = append(f.bodies, string(body))
+	f.headers = append(f.headers, r.Header)
+	if f.failures > 0 {
+		f.failures--
+		w.WriteHeader(f.status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newFakeWebhook(t *testing.T, failures, status int) (*fakeWebhook, string) {
+	f := &fakeWebhook{failures: failures, status: status}
+	ts := httptest.NewServer(f)
+	t.Cleanup(ts.Close)
+	return f, ts.URL
+}
+
+var testEvent = Event{
+	Action:   "failure",
+	Username: "alice",
+	Ecode:    "E3008",
+	Message:  "连线数超额",
+	Hostname: "gateway",
+	Time:     time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC),
+}
+
+func TestNotifier(t *testing.T) {
+	Convey("Notifier should post events as JSON", t, func() {
+		f, url := newFakeWebhook(t, 0, 0)
+		n := &Notifier{Webhooks: []Webhook{{URL: url, Headers: map[string]string{"Authorization": "Bearer token"}}}}
+		So(n.Notify(context.Background(), testEvent), ShouldBeNil)
+		So(len(f.bodies), ShouldEqual, 1)
+		var ev Event
+		So(json.Unmarshal([]byte(f.bodies[0]), &ev), ShouldBeNil)
+		So(ev, ShouldResemble, testEvent)
+		So(f.headers[0].Get("Content-Type"), ShouldEqual, "application/json")
+		So(f.headers[0].Get("Authorization"), ShouldEqual, "Bearer token")
+	})
+
+	Convey("Notifier should render templates", t, func() {
+		f, url := newFakeWebhook(t, 0, 0)
+		n := &Notifier{Webhooks: []Webhook{{
+			URL:      url,
+			Template: `{"text": {{json (printf "%s on %s: %s %s" .Action .Hostname .Ecode .Message)}}}`,
+		}}}
+		So(n.Webhooks[0].Validate(), ShouldBeNil)
+		So(n.Notify(context.Background(), testEvent), ShouldBeNil)
+		So(f.bodies, ShouldResemble, []string{`{"text": "failure on gateway: E3008 连线数超额"}`})
+
+		So((&Webhook{URL: url, Template: "{{.Nonexistent"}).Validate(), ShouldNotBeNil)
+		So((&Webhook{URL: "ftp://example.com"}).Validate(), ShouldNotBeNil)
+	})
+
+	Convey("Notifier should filter events and ecodes", t, func() {
+		f, url := newFakeWebhook(t, 0, 0)
+		n := &Notifier{Webhooks: []Webhook{{URL: url, Events: []string{"failure", "keepalive-lost"}, Ecodes: []string{"E3008", "E2616"}}}}
+		So(n.Notify(context.Background(), Event{Action: "login"}), ShouldBeNil)
+		So(n.Notify(context.Background(), Event{Action: "failure", Ecode: "E2553"}), ShouldBeNil)
+		So(len(f.bodies), ShouldEqual, 0)
+		So(n.Notify(context.Background(), testEvent), ShouldBeNil)
+		So(n.Notify(context.Background(), Event{Action: "keepalive-lost"}), ShouldBeNil)
+		So(len(f.bodies), ShouldEqual, 2)
+	})
+
+	Convey("Notifier should retry transient failures", t, func() {
+		f, url := newFakeWebhook(t, 2, http.StatusBadGateway)
+		n := &Notifier{Webhooks: []Webhook{{URL: url, Retries: 2}}, RetryDelay: time.Millisecond}
+		So(n.Notify(context.Background(), testEvent), ShouldBeNil)
+		So(len(f.bodies), ShouldEqual, 3)
+
+		f, url = newFakeWebhook(t, 5, http.StatusServiceUnavailable)
+		n = &Notifier{Webhooks: []Webhook{{URL: url, Retries: 1}}, RetryDelay: time.Millisecond}
+		So(n.Notify(context.Background(), testEvent), ShouldNotBeNil)
+		So(len(f.bodies), ShouldEqual, 2)
+
+		f, url = newFakeWebhook(t, 5, http.StatusBadRequest)
+		n = &Notifier{Webhooks: []Webhook{{URL: url, Retries: 3}}, RetryDelay: time.Millisecond}
+		So(n.Notify(context.Background(), testEvent), ShouldNotBeNil)
+		So(len(f.bodies), ShouldEqual, 1)
+	})
+}